@@ -0,0 +1,424 @@
+// Package bencode implements the bencoding used by BitTorrent metainfo files,
+// tracker responses and peer extension messages (BEP 3).
+//
+// Decoded values use the following Go types:
+//   - byte strings: []byte
+//   - integers:     int64
+//   - lists:        []interface{}
+//   - dictionaries: map[string]interface{}
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes malformed bencoded input. Offset is the position of
+// the offending byte counted from the start of the input.
+type SyntaxError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+// Rule names a BEP 3 canonical-form requirement checked in strict mode.
+type Rule string
+
+const (
+	RuleIntLeadingZero    Rule = "integers must not have leading zeros"
+	RuleNegativeZero      Rule = "i-0e is not a valid integer"
+	RuleStringLeadingZero Rule = "string lengths must not have leading zeros"
+	RuleStringLength      Rule = "string length must not exceed the input"
+	RuleKeyOrder          Rule = "dictionary keys must be sorted"
+	RuleDuplicateKey      Rule = "dictionary keys must be unique"
+	RuleTrailingData      Rule = "no data may follow the top-level value"
+)
+
+// ConformanceError is returned in strict mode when the input is well formed
+// enough to parse but breaks one of the BEP 3 canonical-form rules.
+type ConformanceError struct {
+	Offset int64
+	Rule   Rule
+	Detail string
+}
+
+func (e *ConformanceError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d (%s)", e.Rule, e.Offset, e.Detail)
+}
+
+// RawMessage is an encoded bencode value kept exactly as it appeared in the
+// input. It can be used to delay decoding or to hash a value byte for byte,
+// as is required for the metainfo info dictionary.
+type RawMessage []byte
+
+// Decoder reads bencoded values from an input stream.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64
+
+	// capture accumulates consumed bytes while capturing > 0, so that the
+	// raw encoding of a value can be returned alongside its decoding.
+	capture   []byte
+	capturing int
+
+	strict bool
+	limits Limits
+	depth  int
+
+	// typeErr holds the first type mismatch seen by DecodeInto.
+	typeErr error
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Decoder{r: br}
+	}
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// UseStrict makes the decoder reject input that is not in canonical BEP 3
+// form with a *ConformanceError instead of accepting it silently.
+func (d *Decoder) UseStrict() {
+	d.strict = true
+}
+
+// ExpectEnd returns an error if any input remains. Callers use it after
+// decoding a complete document, such as a torrent file.
+func (d *Decoder) ExpectEnd() error {
+	_, err := d.r.Peek(1)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if d.strict {
+		return d.violation(d.offset, RuleTrailingData, "unexpected data after value")
+	}
+	return d.errorf(d.offset, "unexpected data after value")
+}
+
+// Offset returns the number of bytes consumed so far.
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Decode reads the next bencoded value from the input.
+func (d *Decoder) Decode() (interface{}, error) {
+	return d.decodeValue()
+}
+
+// DecodeRaw reads the next value and returns its encoding unchanged.
+func (d *Decoder) DecodeRaw() (RawMessage, error) {
+	start := len(d.capture)
+	d.capturing++
+	_, err := d.decodeValue()
+	d.capturing--
+	raw := make(RawMessage, len(d.capture)-start)
+	copy(raw, d.capture[start:])
+	if d.capturing == 0 {
+		d.capture = d.capture[:0]
+	}
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// DecodeDictRaw reads a dictionary and returns the raw encoding of each of
+// its values keyed by dictionary key.
+func (d *Decoder) DecodeDictRaw() (map[string]RawMessage, error) {
+	c, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	if c != 'd' {
+		return nil, d.errorf(d.offset, "expected dictionary, found %q", c)
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	dict := map[string]RawMessage{}
+	var prevKey []byte
+	for n := 0; ; {
+		more, err := d.next(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return dict, nil
+		}
+		key, err := d.readKey(prevKey)
+		if err != nil {
+			return nil, err
+		}
+		prevKey = key
+		raw, err := d.DecodeRaw()
+		if err != nil {
+			return nil, err
+		}
+		dict[string(key)] = raw
+	}
+}
+
+// DecodeBytes decodes the first bencoded value in data. Any bytes following
+// that value are ignored.
+func DecodeBytes(data []byte) (interface{}, error) {
+	return NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+// DecodeString is like DecodeBytes but takes a string.
+func DecodeString(s string) (interface{}, error) {
+	return DecodeBytes([]byte(s))
+}
+
+// DecodeBytesStrict decodes data, which must hold exactly one value in
+// canonical BEP 3 form.
+func DecodeBytesStrict(data []byte) (interface{}, error) {
+	d := NewDecoder(bytes.NewReader(data))
+	d.UseStrict()
+	v, err := d.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.ExpectEnd(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (d *Decoder) errorf(offset int64, format string, args ...interface{}) error {
+	return &SyntaxError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (d *Decoder) violation(offset int64, rule Rule, format string, args ...interface{}) error {
+	return &ConformanceError{Offset: offset, Rule: rule, Detail: fmt.Sprintf(format, args...)}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.limits.MaxBytes > 0 && d.offset >= d.limits.MaxBytes {
+		return 0, d.limitError(d.offset, d.limits.MaxBytes, ErrMaxBytes)
+	}
+	c, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, d.errorf(d.offset, "unexpected end of input")
+	}
+	if err != nil {
+		return 0, err
+	}
+	d.offset++
+	if d.capturing > 0 {
+		d.capture = append(d.capture, c)
+	}
+	return c, nil
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err == io.EOF {
+		return 0, d.errorf(d.offset, "unexpected end of input")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *Decoder) decodeValue() (interface{}, error) {
+	c, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c >= '0' && c <= '9':
+		return d.readString()
+	case c == 'i':
+		return d.readInt()
+	case c == 'l':
+		return d.readList()
+	case c == 'd':
+		return d.readDict()
+	default:
+		return nil, d.errorf(d.offset, "invalid value type %q", c)
+	}
+}
+
+// readDigits consumes an optionally signed run of decimal digits up to and
+// including the terminator byte, returning the digits.
+func (d *Decoder) readDigits(terminator byte, what string) (string, error) {
+	start := d.offset
+	var buf []byte
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return "", err
+		}
+		if c == terminator {
+			break
+		}
+		if !(c >= '0' && c <= '9') && !(c == '-' && len(buf) == 0) {
+			return "", d.errorf(d.offset-1, "invalid character %q in %s", c, what)
+		}
+		// Nothing that fits in an int64 needs more than 20 characters.
+		if len(buf) == 20 {
+			return "", d.errorf(start, "%s too long", what)
+		}
+		buf = append(buf, c)
+	}
+	if len(buf) == 0 || (len(buf) == 1 && buf[0] == '-') {
+		return "", d.errorf(start, "empty %s", what)
+	}
+	return string(buf), nil
+}
+
+func (d *Decoder) readString() ([]byte, error) {
+	start := d.offset
+	digits, err := d.readDigits(':', "string length")
+	if err != nil {
+		return nil, err
+	}
+	if digits[0] == '-' {
+		return nil, d.errorf(start, "negative string length")
+	}
+	if d.strict && len(digits) > 1 && digits[0] == '0' {
+		return nil, d.violation(start, RuleStringLeadingZero, "length %s", digits)
+	}
+	length, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil, d.errorf(start, "string length %s out of range", digits)
+	}
+	if d.limits.MaxStringLen > 0 && length > d.limits.MaxStringLen {
+		return nil, d.limitError(start, d.limits.MaxStringLen, ErrMaxStringLen)
+	}
+	if d.limits.MaxBytes > 0 && length > d.limits.MaxBytes-d.offset {
+		return nil, d.limitError(start, d.limits.MaxBytes, ErrMaxBytes)
+	}
+	// Copy incrementally so a bogus length cannot force a huge allocation
+	// before we discover the input is shorter than claimed.
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, d.r, length)
+	d.offset += n
+	if err == io.EOF {
+		if d.strict {
+			return nil, d.violation(start, RuleStringLength, "length %d, only %d bytes left", length, n)
+		}
+		return nil, d.errorf(start, "string length %d exceeds end of input", length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.capturing > 0 {
+		d.capture = append(d.capture, buf.Bytes()...)
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *Decoder) readInt() (int64, error) {
+	start := d.offset
+	if _, err := d.readByte(); err != nil {
+		return 0, err
+	}
+	digits, err := d.readDigits('e', "integer")
+	if err != nil {
+		return 0, err
+	}
+	if d.strict {
+		if digits == "-0" {
+			return 0, d.violation(start, RuleNegativeZero, "i%se", digits)
+		}
+		unsigned := strings.TrimPrefix(digits, "-")
+		if len(unsigned) > 1 && unsigned[0] == '0' {
+			return 0, d.violation(start, RuleIntLeadingZero, "i%se", digits)
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, d.errorf(start, "integer %s out of range", digits)
+	}
+	return n, nil
+}
+
+func (d *Decoder) readList() ([]interface{}, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	list := []interface{}{}
+	for n := 0; ; {
+		more, err := d.next(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return list, nil
+		}
+		v, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+func (d *Decoder) readDict() (map[string]interface{}, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	dict := map[string]interface{}{}
+	var prevKey []byte
+	for n := 0; ; {
+		more, err := d.next(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return dict, nil
+		}
+		key, err := d.readKey(prevKey)
+		if err != nil {
+			return nil, err
+		}
+		prevKey = key
+		v, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		dict[string(key)] = v
+	}
+}
+
+// readKey reads a dictionary key. In strict mode it also checks the key
+// sorts after prev, the key preceding it in the same dictionary.
+func (d *Decoder) readKey(prev []byte) ([]byte, error) {
+	start := d.offset
+	c, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	if !(c >= '0' && c <= '9') {
+		return nil, d.errorf(d.offset, "dictionary key must be a string, found %q", c)
+	}
+	key, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	if d.strict && prev != nil {
+		switch bytes.Compare(prev, key) {
+		case 0:
+			return nil, d.violation(start, RuleDuplicateKey, "key %q repeated", key)
+		case 1:
+			return nil, d.violation(start, RuleKeyOrder, "key %q after %q", key, prev)
+		}
+	}
+	return key, nil
+}
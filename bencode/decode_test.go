@@ -0,0 +1,131 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stringReader(s string) *strings.Reader {
+	return strings.NewReader(s)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"0:", []byte{}},
+		{"4:spam", []byte("spam")},
+		{"i0e", int64(0)},
+		{"i-42e", int64(-42)},
+		{"i9223372036854775807e", int64(9223372036854775807)},
+		{"le", []interface{}{}},
+		{"l4:spami42ee", []interface{}{[]byte("spam"), int64(42)}},
+		{"de", map[string]interface{}{}},
+		{"d3:cow3:moo4:spaml1:a1:bee", map[string]interface{}{
+			"cow":  []byte("moo"),
+			"spam": []interface{}{[]byte("a"), []byte("b")},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := DecodeString(tt.in)
+		if err != nil {
+			t.Errorf("DecodeString(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DecodeString(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeSyntaxError(t *testing.T) {
+	tests := []struct {
+		in     string
+		offset int64
+	}{
+		{"", 0},
+		{"x", 0},
+		{"-1:a", 0},
+		{"i12", 3},
+		{"i1x2e", 2},
+		{"ie", 1},
+		{"i-e", 1},
+		{"i99999999999999999999e", 0},
+		{"5:abc", 0},
+		{"1a:b", 1},
+		{"l1:a", 4},
+		{"li1e", 4},
+		{"d1:a", 4},
+		{"di1e1:ae", 1},
+		{"l1:ax", 4},
+	}
+	for _, tt := range tests {
+		_, err := DecodeString(tt.in)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("DecodeString(%q) error = %v, want *SyntaxError", tt.in, err)
+			continue
+		}
+		if syntaxErr.Offset != tt.offset {
+			t.Errorf("DecodeString(%q) error offset = %d, want %d (%v)", tt.in, syntaxErr.Offset, tt.offset, err)
+		}
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	in := "d4:infod6:lengthi5ee4:name1:xe"
+	dict, err := NewDecoder(stringReader(in)).DecodeDictRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(dict["info"]); got != "d6:lengthi5ee" {
+		t.Errorf("info = %q, want %q", got, "d6:lengthi5ee")
+	}
+	if got := string(dict["name"]); got != "1:x" {
+		t.Errorf("name = %q, want %q", got, "1:x")
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		in     string
+		rule   Rule
+		offset int64
+	}{
+		{"i03e", RuleIntLeadingZero, 0},
+		{"i-03e", RuleIntLeadingZero, 0},
+		{"li1ei00ee", RuleIntLeadingZero, 4},
+		{"i-0e", RuleNegativeZero, 0},
+		{"02:ab", RuleStringLeadingZero, 0},
+		{"5:abc", RuleStringLength, 0},
+		{"d1:bi1e1:ai2ee", RuleKeyOrder, 7},
+		{"d2:aai1e1:ai2ee", RuleKeyOrder, 8},
+		{"d1:ai1e1:ai2ee", RuleDuplicateKey, 7},
+		{"i1ei2e", RuleTrailingData, 3},
+		{"4:spamx", RuleTrailingData, 6},
+	}
+	for _, tt := range tests {
+		_, err := DecodeBytesStrict([]byte(tt.in))
+		var conformanceErr *ConformanceError
+		if !errors.As(err, &conformanceErr) {
+			t.Errorf("DecodeBytesStrict(%q) error = %v, want *ConformanceError", tt.in, err)
+			continue
+		}
+		if conformanceErr.Rule != tt.rule || conformanceErr.Offset != tt.offset {
+			t.Errorf("DecodeBytesStrict(%q) = %q at offset %d, want %q at offset %d",
+				tt.in, conformanceErr.Rule, conformanceErr.Offset, tt.rule, tt.offset)
+		}
+	}
+}
+
+func TestDecodeLenient(t *testing.T) {
+	// Outside strict mode, non-canonical but parseable input is accepted.
+	for _, in := range []string{"i03e", "i-0e", "02:ab", "d1:bi1e1:ai2ee", "d1:ai1e1:ai2ee", "i1ei2e"} {
+		if _, err := DecodeString(in); err != nil {
+			t.Errorf("DecodeString(%q): %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// UnsupportedTypeError is returned when a value has no bencode representation.
+type UnsupportedTypeError struct {
+	Value interface{}
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("bencode: unsupported type %T", e.Value)
+}
+
+// Encoder writes bencoded values to an output stream. Output is canonical:
+// dictionary keys are sorted by their raw bytes and strings are written
+// verbatim, so binary data survives unchanged.
+type Encoder struct {
+	w *bufio.Writer
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes the bencoding of v. See Marshal for how Go values map to
+// bencode.
+func (e *Encoder) Encode(v interface{}) error {
+	if err := e.encodeValue(v); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// EncodeBytes returns the bencoding of v.
+func EncodeBytes(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Encoder) encodeValue(v interface{}) error {
+	switch v := v.(type) {
+	case []byte:
+		e.writeString(v)
+	case string:
+		e.writeString([]byte(v))
+	case int:
+		e.writeInt(int64(v))
+	case int8:
+		e.writeInt(int64(v))
+	case int16:
+		e.writeInt(int64(v))
+	case int32:
+		e.writeInt(int64(v))
+	case int64:
+		e.writeInt(v)
+	case uint:
+		e.writeUint(uint64(v))
+	case uint8:
+		e.writeUint(uint64(v))
+	case uint16:
+		e.writeUint(uint64(v))
+	case uint32:
+		e.writeUint(uint64(v))
+	case uint64:
+		e.writeUint(v)
+	case []interface{}:
+		e.w.WriteByte('l')
+		for _, item := range v {
+			if err := e.encodeValue(item); err != nil {
+				return err
+			}
+		}
+		e.w.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.w.WriteByte('d')
+		for _, k := range keys {
+			e.writeString([]byte(k))
+			if err := e.encodeValue(v[k]); err != nil {
+				return err
+			}
+		}
+		e.w.WriteByte('e')
+	case nil:
+		return &UnsupportedTypeError{Value: v}
+	default:
+		return e.encodeReflect(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (e *Encoder) writeString(s []byte) {
+	e.w.WriteString(strconv.Itoa(len(s)))
+	e.w.WriteByte(':')
+	e.w.Write(s)
+}
+
+func (e *Encoder) writeInt(n int64) {
+	e.w.WriteByte('i')
+	e.w.WriteString(strconv.FormatInt(n, 10))
+	e.w.WriteByte('e')
+}
+
+func (e *Encoder) writeUint(n uint64) {
+	e.w.WriteByte('i')
+	e.w.WriteString(strconv.FormatUint(n, 10))
+	e.w.WriteByte('e')
+}
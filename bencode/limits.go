@@ -0,0 +1,87 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits bounds the resources a Decoder will spend on its input, so that
+// untrusted data from trackers and peers can't exhaust memory or stack.
+// A zero field means no limit.
+type Limits struct {
+	// MaxDepth is the deepest nesting of lists and dictionaries allowed.
+	MaxDepth int
+	// MaxStringLen is the longest byte string allowed.
+	MaxStringLen int64
+	// MaxEntries is the most items allowed in a single list or dictionary.
+	MaxEntries int
+	// MaxBytes is the most input the decoder will consume in total.
+	MaxBytes int64
+}
+
+// Errors wrapped by *LimitError, for use with errors.Is.
+var (
+	ErrMaxDepth     = errors.New("bencode: nesting too deep")
+	ErrMaxStringLen = errors.New("bencode: string too long")
+	ErrMaxEntries   = errors.New("bencode: too many list or dictionary entries")
+	ErrMaxBytes     = errors.New("bencode: input too large")
+)
+
+// LimitError is returned when input exceeds one of the decoder's Limits.
+type LimitError struct {
+	Offset int64
+	Limit  int64
+	Err    error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v (limit %d) at offset %d", e.Err, e.Limit, e.Offset)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// SetLimits applies limits to everything the decoder reads from now on.
+func (d *Decoder) SetLimits(limits Limits) {
+	d.limits = limits
+}
+
+func (d *Decoder) limitError(offset int64, limit int64, err error) error {
+	return &LimitError{Offset: offset, Limit: limit, Err: err}
+}
+
+// open consumes the 'l' or 'd' starting a list or dictionary.
+func (d *Decoder) open() error {
+	start := d.offset
+	if _, err := d.readByte(); err != nil {
+		return err
+	}
+	d.depth++
+	if d.limits.MaxDepth > 0 && d.depth > d.limits.MaxDepth {
+		return d.limitError(start, int64(d.limits.MaxDepth), ErrMaxDepth)
+	}
+	return nil
+}
+
+// next reports whether another entry follows in the list or dictionary
+// being read, consuming the closing 'e' when it doesn't. n counts the
+// entries seen so far.
+func (d *Decoder) next(n *int) (bool, error) {
+	c, err := d.peekByte()
+	if err != nil {
+		return false, err
+	}
+	if c == 'e' {
+		if _, err := d.readByte(); err != nil {
+			return false, err
+		}
+		d.depth--
+		return false, nil
+	}
+	*n++
+	if d.limits.MaxEntries > 0 && *n > d.limits.MaxEntries {
+		return false, d.limitError(d.offset, int64(d.limits.MaxEntries), ErrMaxEntries)
+	}
+	return true, nil
+}
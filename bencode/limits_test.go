@@ -0,0 +1,62 @@
+package bencode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		in     string
+		limits Limits
+		err    error
+		offset int64
+	}{
+		{"llleee", Limits{MaxDepth: 2}, ErrMaxDepth, 2},
+		{"d1:ad1:ad1:alleeee", Limits{MaxDepth: 3}, ErrMaxDepth, 12},
+		{"4:abcd", Limits{MaxStringLen: 3}, ErrMaxStringLen, 0},
+		{"l3:abc4:abcde", Limits{MaxStringLen: 3}, ErrMaxStringLen, 6},
+		{"li1ei2ei3ee", Limits{MaxEntries: 2}, ErrMaxEntries, 7},
+		{"d1:ai1e1:bi2ee", Limits{MaxEntries: 1}, ErrMaxEntries, 7},
+		{"10:abcdefghij", Limits{MaxBytes: 5}, ErrMaxBytes, 0},
+		{"i12345e", Limits{MaxBytes: 4}, ErrMaxBytes, 4},
+		// The limit falls exactly on a closing 'e'.
+		{"li1ee", Limits{MaxBytes: 4}, ErrMaxBytes, 4},
+		{"lli1eee", Limits{MaxBytes: 5}, ErrMaxBytes, 5},
+	}
+	for _, tt := range tests {
+		d := NewDecoder(stringReader(tt.in))
+		d.SetLimits(tt.limits)
+		_, err := d.Decode()
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || !errors.Is(err, tt.err) {
+			t.Errorf("Decode(%q) with %+v error = %v, want %v", tt.in, tt.limits, err, tt.err)
+			continue
+		}
+		if limitErr.Offset != tt.offset {
+			t.Errorf("Decode(%q) with %+v error offset = %d, want %d", tt.in, tt.limits, limitErr.Offset, tt.offset)
+		}
+	}
+}
+
+func TestWithinLimits(t *testing.T) {
+	tests := []struct {
+		in     string
+		limits Limits
+	}{
+		{"llee", Limits{MaxDepth: 2}},
+		{"3:abc", Limits{MaxStringLen: 3}},
+		{"li1ei2ee", Limits{MaxEntries: 2}},
+		{"li1ee", Limits{MaxBytes: 5}},
+	}
+	for _, tt := range tests {
+		d := NewDecoder(stringReader(tt.in))
+		d.SetLimits(tt.limits)
+		if _, err := d.Decode(); err != nil {
+			t.Errorf("Decode(%q) with %+v: %v", tt.in, tt.limits, err)
+		}
+		if d.Offset() != int64(len(tt.in)) {
+			t.Errorf("Decode(%q) with %+v stopped at offset %d, want %d", tt.in, tt.limits, d.Offset(), len(tt.in))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package bencode
+
+// Kind identifies the type of a bencoded value.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInteger
+	KindList
+	KindDict
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindInteger:
+		return "int"
+	case KindList:
+		return "list"
+	case KindDict:
+		return "dict"
+	}
+	return "unknown"
+}
+
+// Node is a decoded value together with where it sits in the input. Start
+// is the offset of its first byte and End the offset just past its last.
+type Node struct {
+	Kind  Kind
+	Start int64
+	End   int64
+
+	String  []byte      // KindString
+	Integer int64       // KindInteger
+	List    []*Node     // KindList
+	Dict    []DictEntry // KindDict, in input order
+}
+
+// DictEntry is one key/value pair of a dictionary Node.
+type DictEntry struct {
+	Key      []byte
+	KeyStart int64
+	Value    *Node
+}
+
+// DecodeNode reads the next value and returns it as a tree of Nodes that
+// records the byte span of every value.
+func (d *Decoder) DecodeNode() (*Node, error) {
+	node := &Node{Start: d.offset}
+	c, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c >= '0' && c <= '9':
+		node.Kind = KindString
+		if node.String, err = d.readString(); err != nil {
+			return nil, err
+		}
+	case c == 'i':
+		node.Kind = KindInteger
+		if node.Integer, err = d.readInt(); err != nil {
+			return nil, err
+		}
+	case c == 'l':
+		node.Kind = KindList
+		if err := d.open(); err != nil {
+			return nil, err
+		}
+		for n := 0; ; {
+			more, err := d.next(&n)
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				break
+			}
+			item, err := d.DecodeNode()
+			if err != nil {
+				return nil, err
+			}
+			node.List = append(node.List, item)
+		}
+	case c == 'd':
+		node.Kind = KindDict
+		if err := d.open(); err != nil {
+			return nil, err
+		}
+		var prevKey []byte
+		for n := 0; ; {
+			more, err := d.next(&n)
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				break
+			}
+			keyStart := d.offset
+			key, err := d.readKey(prevKey)
+			if err != nil {
+				return nil, err
+			}
+			prevKey = key
+			value, err := d.DecodeNode()
+			if err != nil {
+				return nil, err
+			}
+			node.Dict = append(node.Dict, DictEntry{Key: key, KeyStart: keyStart, Value: value})
+		}
+	default:
+		return nil, d.errorf(d.offset, "invalid value type %q", c)
+	}
+	node.End = d.offset
+	return node, nil
+}
@@ -0,0 +1,40 @@
+package bencode
+
+import (
+	"testing"
+)
+
+func TestDecodeNodeSpans(t *testing.T) {
+	in := "d1:ali1ei22ee1:bi3ee"
+	node, err := NewDecoder(stringReader(in)).DecodeNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		node       *Node
+		kind       Kind
+		start, end int64
+	}{
+		{"dict", node, KindDict, 0, 20},
+		{"a", node.Dict[0].Value, KindList, 4, 13},
+		{"a[0]", node.Dict[0].Value.List[0], KindInteger, 5, 8},
+		{"a[1]", node.Dict[0].Value.List[1], KindInteger, 8, 12},
+		{"b", node.Dict[1].Value, KindInteger, 16, 19},
+	}
+	for _, tt := range tests {
+		if tt.node.Kind != tt.kind || tt.node.Start != tt.start || tt.node.End != tt.end {
+			t.Errorf("%s = %v [%d, %d), want %v [%d, %d)", tt.name, tt.node.Kind, tt.node.Start, tt.node.End, tt.kind, tt.start, tt.end)
+		}
+		if got := in[tt.node.Start:tt.node.End]; tt.node.Kind == KindInteger && got[0] != 'i' {
+			t.Errorf("%s spans %q", tt.name, got)
+		}
+	}
+	if node.Dict[0].KeyStart != 1 || node.Dict[1].KeyStart != 13 {
+		t.Errorf("key starts = %d, %d, want 1, 13", node.Dict[0].KeyStart, node.Dict[1].KeyStart)
+	}
+	if node.Dict[0].Value.List[1].Integer != 22 {
+		t.Errorf("a[1] = %d, want 22", node.Dict[0].Value.List[1].Integer)
+	}
+}
@@ -0,0 +1,401 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// UnmarshalTypeError describes a bencode value that can't be stored in the
+// Go value it was decoded into.
+type UnmarshalTypeError struct {
+	Value  string // "string", "integer", "list" or "dictionary"
+	Type   reflect.Type
+	Offset int64
+	Field  string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("bencode: cannot unmarshal %s into field %s of type %s at offset %d", e.Value, e.Field, e.Type, e.Offset)
+	}
+	return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// InvalidUnmarshalError is returned when Unmarshal is given something other
+// than a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "bencode: Unmarshal(nil)"
+	}
+	return fmt.Sprintf("bencode: Unmarshal(non-pointer or nil %s)", e.Type)
+}
+
+// Marshal returns the bencoding of v.
+//
+// Struct fields are encoded as dictionary entries named by their `bencode`
+// tag, or the field name when there is no tag. The tag may be followed by
+// ",omitempty" to leave out zero values, and a tag of "-" skips the field.
+// Nil pointers and interfaces have no bencode form and are always left
+// out of structs. []byte and strings become byte strings, bools become
+// i1e or i0e, and RawMessage values are written unchanged.
+func Marshal(v interface{}) ([]byte, error) {
+	return EncodeBytes(v)
+}
+
+// Unmarshal decodes the first bencoded value in data into the value pointed
+// to by v, following the same rules Marshal uses. Dictionary entries with
+// no matching struct field are ignored. A RawMessage field receives the
+// value's encoding unchanged.
+//
+// If a value has the wrong type for its destination, Unmarshal skips it,
+// carries on, and returns an *UnmarshalTypeError for the first such value.
+func Unmarshal(data []byte, v interface{}) error {
+	return NewDecoder(bytes.NewReader(data)).DecodeInto(v)
+}
+
+// DecodeInto reads the next value from the input and stores it in the value
+// pointed to by v. See Unmarshal for details.
+func (d *Decoder) DecodeInto(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	d.typeErr = nil
+	if err := d.decodeInto(rv.Elem(), ""); err != nil {
+		return err
+	}
+	return d.typeErr
+}
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// field describes how a struct field maps to a dictionary key.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// structFields returns the encodable fields of t sorted by key.
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     i,
+			omitEmpty: options == "omitempty",
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func (d *Decoder) saveTypeError(value string, t reflect.Type, offset int64, fieldName string) {
+	if d.typeErr == nil {
+		d.typeErr = &UnmarshalTypeError{Value: value, Type: t, Offset: offset, Field: fieldName}
+	}
+}
+
+func (d *Decoder) decodeInto(rv reflect.Value, fieldName string) error {
+	if rv.Type() == rawMessageType {
+		raw, err := d.DecodeRaw()
+		if err != nil {
+			return err
+		}
+		rv.SetBytes(raw)
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return d.decodeInto(rv.Elem(), fieldName)
+	case reflect.Interface:
+		if rv.NumMethod() == 0 {
+			v, err := d.decodeValue()
+			if err != nil {
+				return err
+			}
+			rv.Set(reflect.ValueOf(v))
+			return nil
+		}
+	}
+
+	start := d.offset
+	c, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c >= '0' && c <= '9':
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		switch {
+		case rv.Kind() == reflect.String:
+			rv.SetString(string(s))
+		case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+			rv.SetBytes(s)
+		case rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8:
+			// Marshal writes byte arrays as strings, so only a string of
+			// exactly the array's length fits.
+			if len(s) != rv.Len() {
+				d.saveTypeError(fmt.Sprintf("string of %d bytes", len(s)), rv.Type(), start, fieldName)
+				return nil
+			}
+			reflect.Copy(rv, reflect.ValueOf(s))
+		default:
+			d.saveTypeError("string", rv.Type(), start, fieldName)
+		}
+		return nil
+	case c == 'i':
+		n, err := d.readInt()
+		if err != nil {
+			return err
+		}
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if rv.OverflowInt(n) {
+				d.saveTypeError(fmt.Sprintf("integer %d", n), rv.Type(), start, fieldName)
+				return nil
+			}
+			rv.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n < 0 || rv.OverflowUint(uint64(n)) {
+				d.saveTypeError(fmt.Sprintf("integer %d", n), rv.Type(), start, fieldName)
+				return nil
+			}
+			rv.SetUint(uint64(n))
+		case reflect.Bool:
+			rv.SetBool(n != 0)
+		default:
+			d.saveTypeError("integer", rv.Type(), start, fieldName)
+		}
+		return nil
+	case c == 'l':
+		if rv.Kind() != reflect.Slice {
+			d.saveTypeError("list", rv.Type(), start, fieldName)
+			_, err := d.decodeValue()
+			return err
+		}
+		if err := d.open(); err != nil {
+			return err
+		}
+		list := reflect.MakeSlice(rv.Type(), 0, 0)
+		for n := 0; ; {
+			more, err := d.next(&n)
+			if err != nil {
+				return err
+			}
+			if !more {
+				rv.Set(list)
+				return nil
+			}
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := d.decodeInto(elem, fieldName); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+	case c == 'd':
+		switch {
+		case rv.Kind() == reflect.Struct:
+			return d.decodeStruct(rv)
+		case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+			return d.decodeMap(rv, fieldName)
+		default:
+			d.saveTypeError("dictionary", rv.Type(), start, fieldName)
+			_, err := d.decodeValue()
+			return err
+		}
+	default:
+		return d.errorf(d.offset, "invalid value type %q", c)
+	}
+}
+
+func (d *Decoder) decodeStruct(rv reflect.Value) error {
+	fields := structFields(rv.Type())
+	if err := d.open(); err != nil {
+		return err
+	}
+	var prevKey []byte
+	for n := 0; ; {
+		more, err := d.next(&n)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		key, err := d.readKey(prevKey)
+		if err != nil {
+			return err
+		}
+		prevKey = key
+
+		i := sort.Search(len(fields), func(i int) bool { return fields[i].name >= string(key) })
+		if i < len(fields) && fields[i].name == string(key) {
+			if err := d.decodeInto(rv.Field(fields[i].index), rv.Type().Name()+"."+fields[i].name); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := d.decodeValue(); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Decoder) decodeMap(rv reflect.Value, fieldName string) error {
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	var prevKey []byte
+	for n := 0; ; {
+		more, err := d.next(&n)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		key, err := d.readKey(prevKey)
+		if err != nil {
+			return err
+		}
+		prevKey = key
+		elem := reflect.New(rv.Type().Elem()).Elem()
+		if err := d.decodeInto(elem, fieldName); err != nil {
+			return err
+		}
+		rv.SetMapIndex(reflect.ValueOf(string(key)).Convert(rv.Type().Key()), elem)
+	}
+}
+
+func (e *Encoder) encodeReflect(rv reflect.Value) error {
+	if rv.Type() == rawMessageType {
+		if rv.Len() == 0 {
+			return fmt.Errorf("bencode: empty RawMessage")
+		}
+		e.w.Write(rv.Bytes())
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return &UnsupportedTypeError{Value: rv.Interface()}
+		}
+		return e.encodeReflect(rv.Elem())
+	case reflect.String:
+		e.writeString([]byte(rv.String()))
+	case reflect.Bool:
+		if rv.Bool() {
+			e.writeInt(1)
+		} else {
+			e.writeInt(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.writeUint(rv.Uint())
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			e.writeString(b)
+			return nil
+		}
+		e.w.WriteByte('l')
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.encodeReflect(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		e.w.WriteByte('e')
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return &UnsupportedTypeError{Value: rv.Interface()}
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		e.w.WriteByte('d')
+		for _, k := range keys {
+			e.writeString([]byte(k.String()))
+			if err := e.encodeReflect(rv.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		e.w.WriteByte('e')
+	case reflect.Struct:
+		e.w.WriteByte('d')
+		for _, f := range structFields(rv.Type()) {
+			fv := rv.Field(f.index)
+			if (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface) && fv.IsNil() {
+				continue
+			}
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			e.writeString([]byte(f.name))
+			if err := e.encodeReflect(fv); err != nil {
+				return err
+			}
+		}
+		e.w.WriteByte('e')
+	default:
+		return &UnsupportedTypeError{Value: rv.Interface()}
+	}
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		return false
+	}
+	return false
+}
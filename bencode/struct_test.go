@@ -0,0 +1,167 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string            `bencode:"name"`
+	PieceLength int64             `bencode:"piece length"`
+	Pieces      []byte            `bencode:"pieces"`
+	Hash        [4]byte           `bencode:"hash"`
+	Private     bool              `bencode:"private,omitempty"`
+	Files       []testFile        `bencode:"files,omitempty"`
+	Extra       map[string]string `bencode:"extra,omitempty"`
+	Raw         RawMessage        `bencode:"raw,omitempty"`
+	Offset      int32             `bencode:"offset"`
+	Count       uint16            `bencode:"count"`
+	Skipped     string            `bencode:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []interface{}{
+		&testInfo{Name: "x", PieceLength: 16384, Pieces: []byte{0, 1, 2}, Hash: [4]byte{9, 8, 7, 6}},
+		&testInfo{
+			Name:    "dir",
+			Pieces:  []byte("p"),
+			Private: true,
+			Files:   []testFile{{Length: 1, Path: []string{"a", "b"}}, {Length: 0, Path: []string{"c"}}},
+			Extra:   map[string]string{"z": "last", "a": "first"},
+			Raw:     RawMessage("li1e3:fooe"),
+			Offset:  -5,
+			Count:   65535,
+		},
+		&map[string]int64{"b": 2, "a": -1},
+		&[]string{"", "spam"},
+		&[20]byte{1, 2, 3},
+	}
+	for _, in := range tests {
+		encoded, err := Marshal(in)
+		if err != nil {
+			t.Errorf("Marshal(%+v): %v", in, err)
+			continue
+		}
+		// Marshal must produce canonical bencoding.
+		if _, err := DecodeBytesStrict(encoded); err != nil {
+			t.Errorf("Marshal(%+v) = %q, not canonical: %v", in, encoded, err)
+		}
+		out := reflect.New(reflect.TypeOf(in).Elem())
+		if err := Unmarshal(encoded, out.Interface()); err != nil {
+			t.Errorf("Unmarshal(%q): %v", encoded, err)
+			continue
+		}
+		if !reflect.DeepEqual(out.Interface(), in) {
+			t.Errorf("round trip of %+v gave %+v", in, out.Interface())
+		}
+	}
+}
+
+func TestReencode(t *testing.T) {
+	// Decoding canonical input and encoding it again gives the same bytes.
+	for _, in := range []string{
+		"i-7e",
+		"0:",
+		"l4:spami42eli0eee",
+		"d3:cow3:moo4:spaml1:a1:bee",
+		"d1:ad1:bde1:cleee",
+	} {
+		v, err := DecodeString(in)
+		if err != nil {
+			t.Errorf("DecodeString(%q): %v", in, err)
+			continue
+		}
+		out, err := Marshal(v)
+		if err != nil {
+			t.Errorf("Marshal(%q): %v", in, err)
+			continue
+		}
+		decodedAgain, err := DecodeBytes(out)
+		if err != nil || !reflect.DeepEqual(decodedAgain, v) {
+			t.Errorf("re-encoding %q gave %q", in, out)
+		}
+	}
+}
+
+func TestMarshalString(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{"spam", "4:spam"},
+		{[]byte{}, "0:"},
+		{[3]byte{'a', 'b', 'c'}, "3:abc"},
+		{int64(-3), "i-3e"},
+		{uint64(18446744073709551615), "i18446744073709551615e"},
+		{true, "i1e"},
+		{false, "i0e"},
+		{[]int{1, 2}, "li1ei2ee"},
+		{map[string]int{"b": 1, "a": 2}, "d1:ai2e1:bi1ee"},
+		{testFile{Length: 3, Path: []string{"x"}}, "d6:lengthi3e4:pathl1:xee"},
+		{RawMessage("i5e"), "i5e"},
+	}
+	for _, tt := range tests {
+		got, err := Marshal(tt.in)
+		if err != nil {
+			t.Errorf("Marshal(%#v): %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Marshal(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	tests := []struct {
+		in     string
+		offset int64
+	}{
+		{"d12:piece length3:abce", 16},
+		{"d4:hash3:abce", 7},
+		{"d6:offseti9999999999ee", 9},
+		{"d5:counti-1ee", 8},
+		{"d5:filesd1:ai1eee", 8},
+	}
+	for _, tt := range tests {
+		var info testInfo
+		err := Unmarshal([]byte(tt.in), &info)
+		var typeErr *UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("Unmarshal(%q) error = %v, want *UnmarshalTypeError", tt.in, err)
+			continue
+		}
+		if typeErr.Offset != tt.offset {
+			t.Errorf("Unmarshal(%q) error offset = %d, want %d (%v)", tt.in, typeErr.Offset, tt.offset, err)
+		}
+	}
+}
+
+func TestUnmarshalKeepsGoing(t *testing.T) {
+	// A mistyped field is reported but the rest are still filled in.
+	var info testInfo
+	err := Unmarshal([]byte("d4:name1:x12:piece length3:abc6:pieces2:pqe"), &info)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("error = %v, want *UnmarshalTypeError", err)
+	}
+	if info.Name != "x" || string(info.Pieces) != "pq" {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	var info testInfo
+	for _, v := range []interface{}{nil, info, (*testInfo)(nil)} {
+		var invalidErr *InvalidUnmarshalError
+		if err := Unmarshal([]byte("de"), v); !errors.As(err, &invalidErr) {
+			t.Errorf("Unmarshal into %T: error = %v, want *InvalidUnmarshalError", v, err)
+		}
+	}
+}
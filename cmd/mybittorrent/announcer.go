@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// AnnounceEvent is the event sent with an announce.
+type AnnounceEvent string
+
+const (
+	// EventNone is a regular announce during a download.
+	EventNone      AnnounceEvent = ""
+	EventStarted   AnnounceEvent = "started"
+	EventCompleted AnnounceEvent = "completed"
+	EventStopped   AnnounceEvent = "stopped"
+)
+
+const (
+	// defaultNumWant is how many peers we ask trackers for.
+	defaultNumWant = 50
+	// defaultInterval is used when a tracker doesn't give an interval.
+	defaultInterval = 30 * time.Minute
+)
+
+// TrackerError is a tracker's refusal of an announce, with its failure
+// reason.
+type TrackerError struct {
+	URL    string
+	Reason string
+	// RetryIn is how long the tracker asks us to wait before announcing
+	// again (BEP 31); zero when it didn't say.
+	RetryIn time.Duration
+	// NeverRetry is set when the tracker asks never to be retried.
+	NeverRetry bool
+}
+
+func (e *TrackerError) Error() string {
+	return fmt.Sprintf("tracker %s failed: %s", e.URL, e.Reason)
+}
+
+func newTrackerError(trackerURL string, trackerResponse TrackerResponse) *TrackerError {
+	trackerErr := &TrackerError{URL: trackerURL, Reason: trackerResponse.FailureReason}
+	if len(trackerResponse.RetryIn) > 0 {
+		var minutes int64
+		var never string
+		if bencode.Unmarshal(trackerResponse.RetryIn, &minutes) == nil && minutes > 0 {
+			trackerErr.RetryIn = time.Duration(minutes) * time.Minute
+		} else if bencode.Unmarshal(trackerResponse.RetryIn, &never) == nil && never == "never" {
+			trackerErr.NeverRetry = true
+		}
+	}
+	return trackerErr
+}
+
+// Announcer announces one torrent to its trackers over a download, failing
+// over between them as BEP 12 describes. It keeps the transfer counters
+// that announces report and the tracker ids trackers hand out. It is safe
+// for concurrent use.
+type Announcer struct {
+	torrentInfo TorrentInfo
+	tiers       trackerTiers
+	peerID      string
+	port        int
+	key         uint32
+	numWant     int
+	// ipv6 is our global IPv6 address, if we have one, which lets a
+	// tracker reached over IPv4 list us to IPv6 peers too (BEP 7).
+	ipv6 string
+
+	// announcing is held for a whole announce, which reorders tiers.
+	announcing sync.Mutex
+
+	mu         sync.Mutex
+	uploaded   int64
+	downloaded int64
+	trackerIDs map[string]string
+	// retryAfter holds when trackers that refused us, asking to be left
+	// alone for a while, may be tried again; the zero time means never.
+	retryAfter map[string]time.Time
+	// next is when the next regular announce is due.
+	next time.Time
+}
+
+// HasTrackers reports whether the torrent has any trackers to announce to.
+func (a *Announcer) HasTrackers() bool {
+	return len(a.tiers) > 0
+}
+
+// NewAnnouncer returns an announcer for torrentInfo. Its key, which lets
+// trackers recognise us if our address changes, is random per announcer.
+func NewAnnouncer(torrentInfo TorrentInfo) *Announcer {
+	return &Announcer{
+		torrentInfo: torrentInfo,
+		tiers:       newTrackerTiers(torrentInfo),
+		peerID:      "00112233445566778899",
+		port:        6881,
+		key:         trackerRand.Uint32(),
+		numWant:     defaultNumWant,
+		ipv6:        localIPv6(),
+		trackerIDs:  make(map[string]string),
+		retryAfter:  make(map[string]time.Time),
+	}
+}
+
+// SetTorrentInfo replaces what the announcer knows of the torrent, as when
+// the metadata of a magnet link arrives and with it how much is left.
+func (a *Announcer) SetTorrentInfo(torrentInfo TorrentInfo) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.torrentInfo = torrentInfo
+}
+
+// AddDownloaded counts n more bytes of verified content downloaded.
+func (a *Announcer) AddDownloaded(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.downloaded += n
+}
+
+// AddUploaded counts n more bytes uploaded to peers.
+func (a *Announcer) AddUploaded(n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.uploaded += n
+}
+
+// Due reports whether the interval the tracker asked for has passed since
+// the last announce.
+func (a *Announcer) Due() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.next.IsZero() && time.Now().After(a.next)
+}
+
+// Announce sends event with the current counters to the first tracker
+// that answers. A tracker's refusal is returned as a *TrackerError,
+// possibly wrapped when other trackers failed as well.
+func (a *Announcer) Announce(event AnnounceEvent) (TrackerResponse, error) {
+	a.announcing.Lock()
+	defer a.announcing.Unlock()
+	trackerResponse, err := a.tiers.announce(func(trackerURL string) (TrackerResponse, error) {
+		if err := a.checkRetry(trackerURL); err != nil {
+			return TrackerResponse{}, err
+		}
+		trackerResponse, err := announceToTracker(trackerURL, a.request(trackerURL, event))
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		var trackerErr *TrackerError
+		switch {
+		case errors.As(err, &trackerErr) && trackerErr.NeverRetry:
+			a.retryAfter[trackerURL] = time.Time{}
+		case errors.As(err, &trackerErr) && trackerErr.RetryIn > 0:
+			a.retryAfter[trackerURL] = time.Now().Add(trackerErr.RetryIn)
+		case err == nil && trackerResponse.TrackerID != "":
+			a.trackerIDs[trackerURL] = trackerResponse.TrackerID
+		}
+		return trackerResponse, err
+	})
+	if err != nil {
+		return TrackerResponse{}, err
+	}
+	if trackerResponse.WarningMessage != "" {
+		log.Printf("Tracker warning: %s", trackerResponse.WarningMessage)
+	}
+
+	interval := time.Duration(trackerResponse.Interval) * time.Second
+	if minInterval := time.Duration(trackerResponse.MinInterval) * time.Second; interval < minInterval {
+		interval = minInterval
+	}
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	a.mu.Lock()
+	a.next = time.Now().Add(interval)
+	a.mu.Unlock()
+	return trackerResponse, nil
+}
+
+// checkRetry fails if trackerURL asked not to be announced to yet.
+func (a *Announcer) checkRetry(trackerURL string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	retryAfter, ok := a.retryAfter[trackerURL]
+	switch {
+	case !ok:
+		return nil
+	case retryAfter.IsZero():
+		return fmt.Errorf("tracker asked never to be retried")
+	case time.Now().Before(retryAfter):
+		return fmt.Errorf("tracker asked not to be retried until %s", retryAfter.Format(time.Kitchen))
+	}
+	delete(a.retryAfter, trackerURL)
+	return nil
+}
+
+// request builds an announce of event to trackerURL.
+func (a *Announcer) request(trackerURL string, event AnnounceEvent) announceRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	left := a.torrentInfo.wantedLength() - a.downloaded
+	if left < 0 {
+		left = 0
+	}
+	if a.torrentInfo.RawInfo == nil {
+		// Until the metadata arrives we don't know how much is left, but
+		// some trackers only answer leechers with something left.
+		left = 1
+	}
+
+	return announceRequest{
+		InfoHash:   a.torrentInfo.RawInfoHash,
+		PeerID:     a.peerID,
+		Port:       a.port,
+		Uploaded:   a.uploaded,
+		Downloaded: a.downloaded,
+		Left:       left,
+		Event:      event,
+		Key:        a.key,
+		NumWant:    a.numWant,
+		TrackerID:  a.trackerIDs[trackerURL],
+		IPv6:       a.ipv6,
+	}
+}
+
+// localIPv6 returns a global unicast IPv6 address of this host, or "" if it
+// has none.
+func localIPv6() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		// Unique local addresses (fc00::/7) aren't reachable from outside.
+		if ipNet.IP[0]&0xfe == 0xfc {
+			continue
+		}
+		return ipNet.IP.String()
+	}
+	return ""
+}
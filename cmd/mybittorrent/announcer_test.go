@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAnnouncerConcurrentUse(t *testing.T) {
+	// Every other request fails, so announces keep moving one tracker or
+	// another to the front of the tier while others walk it. Announces may
+	// fail outright; the race detector and the tier's contents are what
+	// is checked.
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%2 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "d8:intervali60e5:peers0:e")
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			announcer := NewAnnouncer(TorrentInfo{
+				AnnounceList: [][]string{{server.URL + "/a", server.URL + "/b", server.URL + "/c"}},
+				RawInfoHash:  make([]byte, 20),
+			})
+			var announces sync.WaitGroup
+			for j := 0; j < 8; j++ {
+				announces.Add(1)
+				go func() {
+					defer announces.Done()
+					announcer.Announce(EventNone)
+					announcer.AddDownloaded(1)
+				}()
+			}
+			announces.Wait()
+			if len(announcer.tiers[0]) != 3 {
+				t.Errorf("tier = %v, lost a tracker", announcer.tiers[0])
+			}
+		}()
+	}
+	wg.Wait()
+}
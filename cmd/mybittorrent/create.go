@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// targetPieceCount is roughly how many pieces automatic piece sizing
+	// aims for: enough for good swarm granularity, few enough to keep the
+	// metainfo small.
+	targetPieceCount = 1500
+)
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// TorrentCreateOptions controls what createTorrent writes besides the
+// content hashes.
+type TorrentCreateOptions struct {
+	// PieceLength is the piece size in bytes; 0 picks one automatically.
+	PieceLength int64
+	// Trackers is the announce-list, one slice per tier. The first tracker
+	// is also written as announce.
+	Trackers     [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Private      bool
+	Source       string
+	WebSeeds     []string
+}
+
+// runCreate implements the create command:
+//
+//	create [-o out.torrent] [-piece-length N] [-announce url[,url...]]...
+//	       [-comment text] [-created-by text] [-no-date] [-private]
+//	       [-source text] [-web-seed url]... <file or directory>
+//
+// Each -announce flag is one tier; list several URLs in a tier with commas.
+func runCreate(args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	output := flags.String("o", "", "output path (default <name>.torrent)")
+	pieceLength := flags.Int64("piece-length", 0, "piece size in bytes, a power of two (default automatic)")
+	var announce, webSeeds stringList
+	flags.Var(&announce, "announce", "tracker tier, comma separated; repeat for more tiers")
+	flags.Var(&webSeeds, "web-seed", "web seed URL; repeat for more")
+	comment := flags.String("comment", "", "comment")
+	createdBy := flags.String("created-by", "mybittorrent", "created by")
+	noDate := flags.Bool("no-date", false, "leave out the creation date")
+	private := flags.Bool("private", false, "set the private flag (BEP 27)")
+	source := flags.String("source", "", "source tag, stored in the info dictionary")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: create [options] <file or directory>")
+	}
+
+	options := TorrentCreateOptions{
+		PieceLength: *pieceLength,
+		Comment:     *comment,
+		CreatedBy:   *createdBy,
+		Private:     *private,
+		Source:      *source,
+		WebSeeds:    webSeeds,
+	}
+	for _, tier := range announce {
+		options.Trackers = append(options.Trackers, strings.Split(tier, ","))
+	}
+	if !*noDate {
+		options.CreationDate = time.Now()
+	}
+
+	root := flags.Arg(0)
+	content, err := createTorrent(root, options)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		name, err := torrentName(root)
+		if err != nil {
+			return err
+		}
+		*output = name + ".torrent"
+	}
+	if err := os.WriteFile(*output, content, 0644); err != nil {
+		return err
+	}
+
+	torrentInfo, err := getTorrentInfo(string(content))
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created:", *output)
+	fmt.Println("Info Hash:", torrentInfo.InfoHash)
+	fmt.Println("Piece Length:", torrentInfo.PieceLength)
+	fmt.Println("Pieces:", len(torrentInfo.Pieces))
+	return nil
+}
+
+// createTorrent builds the metainfo for the file or directory at root.
+func createTorrent(root string, options TorrentCreateOptions) ([]byte, error) {
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	name, err := torrentName(root)
+	if err != nil {
+		return nil, err
+	}
+	info := MetadataInfo{
+		Name:    name,
+		Private: options.Private,
+		Source:  options.Source,
+	}
+	var paths []string
+	var totalLength int64
+	if stat.IsDir() {
+		err = filepath.Walk(root, func(path string, fileInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fileInfo.Mode().IsRegular() {
+				return nil
+			}
+			relative, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			info.Files = append(info.Files, MetadataFile{
+				Length: fileInfo.Size(),
+				Path:   strings.Split(filepath.ToSlash(relative), "/"),
+			})
+			paths = append(paths, path)
+			totalLength += fileInfo.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(info.Files) == 0 {
+			return nil, fmt.Errorf("%s contains no files", root)
+		}
+	} else {
+		info.Length = stat.Size()
+		paths = []string{root}
+		totalLength = stat.Size()
+	}
+	if totalLength == 0 {
+		return nil, fmt.Errorf("%s has no content to hash", root)
+	}
+
+	info.PieceLength = options.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = autoPieceLength(totalLength)
+	}
+	if info.PieceLength < minPieceLength || info.PieceLength&(info.PieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length %d must be a power of two of at least %d", info.PieceLength, minPieceLength)
+	}
+
+	pieces, err := hashPieces(paths, info.PieceLength, totalLength)
+	if err != nil {
+		return nil, err
+	}
+	info.Pieces = string(pieces)
+
+	rawInfo, err := bencode.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	metadata := Metadata{Info: rawInfo}
+	if options.Comment != "" {
+		if metadata.Comment, err = bencode.Marshal(options.Comment); err != nil {
+			return nil, err
+		}
+	}
+	if options.CreatedBy != "" {
+		if metadata.CreatedBy, err = bencode.Marshal(options.CreatedBy); err != nil {
+			return nil, err
+		}
+	}
+	if !options.CreationDate.IsZero() {
+		if metadata.CreationDate, err = bencode.Marshal(options.CreationDate.Unix()); err != nil {
+			return nil, err
+		}
+	}
+	if len(options.Trackers) > 0 && len(options.Trackers[0]) > 0 {
+		metadata.Announce = options.Trackers[0][0]
+		if len(options.Trackers) > 1 || len(options.Trackers[0]) > 1 {
+			metadata.AnnounceList = options.Trackers
+		}
+	}
+	if len(options.WebSeeds) > 0 {
+		if metadata.URLList, err = bencode.Marshal(options.WebSeeds); err != nil {
+			return nil, err
+		}
+	}
+	return bencode.Marshal(metadata)
+}
+
+// torrentName returns the name a torrent of root gets: its last path
+// component, taken from the absolute path so that "." and ".." name the
+// directory they stand for.
+func torrentName(root string) (string, error) {
+	absolute, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(absolute)
+	if name == "." || name == string(filepath.Separator) || name == filepath.VolumeName(absolute)+string(filepath.Separator) {
+		return "", fmt.Errorf("%s has no name to give the torrent", root)
+	}
+	return name, nil
+}
+
+// autoPieceLength picks a power-of-two piece size giving about
+// targetPieceCount pieces, within [minPieceLength, maxPieceLength].
+func autoPieceLength(totalLength int64) int64 {
+	pieceLength := int64(minPieceLength)
+	for pieceLength < maxPieceLength && totalLength/pieceLength > targetPieceCount {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of the pieces of the
+// given files laid end to end. Reading is sequential; hashing is spread
+// over one worker per CPU.
+func hashPieces(paths []string, pieceLength int64, totalLength int64) ([]byte, error) {
+	numberOfPieces := (totalLength + pieceLength - 1) / pieceLength
+	hashes := make([]byte, numberOfPieces*sha1.Size)
+
+	type pieceData struct {
+		index int64
+		data  []byte
+	}
+	workers := runtime.NumCPU()
+	work := make(chan pieceData, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for piece := range work {
+				sum := sha1.Sum(piece.data)
+				copy(hashes[piece.index*sha1.Size:], sum[:])
+			}
+		}()
+	}
+
+	var hashed int64
+	err := readPieces(paths, pieceLength, func(index int64, data []byte) error {
+		if index >= numberOfPieces {
+			return fmt.Errorf("content grew while hashing")
+		}
+		work <- pieceData{index: index, data: data}
+		hashed++
+		return nil
+	})
+	close(work)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if hashed != numberOfPieces {
+		return nil, fmt.Errorf("content shrank while hashing")
+	}
+	return hashes, nil
+}
+
+// readPieces reads the files in order and calls fn with each piece. The
+// slice passed to fn is not reused.
+func readPieces(paths []string, pieceLength int64, fn func(index int64, data []byte) error) error {
+	content := &contentReader{paths: paths}
+	defer content.Close()
+	for index := int64(0); ; index++ {
+		data := make([]byte, pieceLength)
+		n, err := io.ReadFull(content, data)
+		if n > 0 {
+			if fnErr := fn(index, data[:n]); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// contentReader reads a list of files as one stream, keeping only the
+// current file open.
+type contentReader struct {
+	paths   []string
+	current *os.File
+}
+
+func (r *contentReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.paths = file, r.paths[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *contentReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateRoundTrip(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "content")
+	files := []struct {
+		path    string
+		content []byte
+	}{
+		{"a.txt", bytes.Repeat([]byte("a"), 20000)},
+		{"sub/b.bin", bytes.Repeat([]byte{1, 2, 3}, 9000)},
+		{"sub/c", []byte("c")},
+	}
+	var content []byte
+	for _, f := range files {
+		path := filepath.Join(root, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, f.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, f.content...)
+	}
+
+	metainfo, err := createTorrent(root, TorrentCreateOptions{
+		PieceLength: minPieceLength,
+		Trackers:    [][]string{{"http://tracker.example/announce"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrentInfo, err := getTorrentInfo(string(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := bencode.NewDecoder(bytes.NewReader(metainfo)).DecodeDictRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha1.Sum(raw["info"])); torrentInfo.InfoHash != want {
+		t.Errorf("info hash = %s, want %s", torrentInfo.InfoHash, want)
+	}
+	if torrentInfo.Name != "content" || torrentInfo.TotalLength != int64(len(content)) {
+		t.Errorf("name %q, length %d; want content, %d", torrentInfo.Name, torrentInfo.TotalLength, len(content))
+	}
+	var paths []string
+	for _, f := range torrentInfo.Files {
+		paths = append(paths, fmt.Sprintf("%s %d", strings.Join(f.Path, "/"), f.Length))
+	}
+	if want := []string{"a.txt 20000", "sub/b.bin 27000", "sub/c 1"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("files = %q, want %q", paths, want)
+	}
+	for i := range torrentInfo.Pieces {
+		end := int64(i+1) * minPieceLength
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		if err := torrentInfo.verifyPiece(i, content[int64(i)*minPieceLength:end]); err != nil {
+			t.Error(err)
+		}
+	}
+	if findings := validateTorrent(metainfo); hasErrors(findings) {
+		t.Errorf("created torrent fails validation: %v", findings)
+	}
+}
+
+func TestTorrentName(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "parent", "child")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tt := range []struct{ root, want string }{
+		{".", "child"},
+		{"..", "parent"},
+		{"./", "child"},
+		{dir + "/", "child"},
+		{"/", ""},
+	} {
+		got, err := torrentName(tt.root)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("torrentName(%q) = %q, want an error", tt.root, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("torrentName(%q) = %q, %v; want %q", tt.root, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// pieceSource is somewhere whole, verified pieces can be fetched from: a
+// peer or a web seed.
+type pieceSource interface {
+	fetchPiece(torrentInfo TorrentInfo, index int) ([]byte, error)
+	Close() error
+	String() string
+}
+
+// blockSize is the size of the blocks pieces are requested from peers in.
+const blockSize = 16384
+
+// peerSource fetches pieces from one peer, connecting on first use.
+type peerSource struct {
+	address string
+	conn    net.Conn
+}
+
+func (p *peerSource) fetchPiece(torrentInfo TorrentInfo, index int) ([]byte, error) {
+	if p.conn == nil {
+		conn, err := connectToPeer(p.address, torrentInfo)
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+	}
+	if !torrentInfo.needsPieceProof(index) {
+		return downloadPiece(torrentInfo, blockSize, index, p.conn)
+	}
+	proof, err := requestPieceProof(p.conn, torrentInfo, index)
+	if err != nil {
+		return nil, err
+	}
+	data, err := requestPiece(torrentInfo, blockSize, index, p.conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := torrentInfo.verifyPieceProof(index, data, proof); err != nil {
+		return nil, fmt.Errorf("piece hashes doesnt match: %w", err)
+	}
+	return data, nil
+}
+
+func (p *peerSource) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.Close()
+}
+
+func (p *peerSource) String() string {
+	return "peer " + p.address
+}
+
+// connectToPeer opens a connection to the peer at address that is ready
+// for requests: handshaken, interested and unchoked.
+func connectToPeer(address string, torrentInfo TorrentInfo) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, peerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	v2 := torrentInfo.MetaVersion == 2
+	if _, err := peerHandshake(conn, torrentInfo.RawInfoHash, false, v2); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = sendMessage(conn, PeerMessage{PayloadLength: 1, Id: interested})
+	// The peer may send its bitfield and haves before unchoking us.
+	for err == nil {
+		var msg *PeerMessage
+		if msg, err = waitForMessage(conn); err == nil && msg.Id == unchoke {
+			return conn, nil
+		}
+	}
+	conn.Close()
+	return nil, err
+}
+
+// newPieceSources returns a source for every peer and web seed of the
+// torrent.
+func newPieceSources(torrentInfo TorrentInfo, peers []string) []pieceSource {
+	var sources []pieceSource
+	for _, address := range peers {
+		sources = append(sources, &peerSource{address: address})
+	}
+	for _, seedURL := range torrentInfo.WebSeeds {
+		sources = append(sources, &webSeed{url: seedURL})
+	}
+	return sources
+}
+
+func closePieceSources(sources []pieceSource) {
+	for _, source := range sources {
+		source.Close()
+	}
+}
+
+// fetchPieceFromAny tries the sources in order until one supplies piece
+// index.
+func fetchPieceFromAny(sources []pieceSource, torrentInfo TorrentInfo, index int) ([]byte, error) {
+	var failures []string
+	for _, source := range sources {
+		data, err := source.fetchPiece(torrentInfo, index)
+		if err == nil {
+			return data, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", source, err))
+	}
+	return nil, fmt.Errorf("no source supplied piece %d: %s", index, strings.Join(failures, "; "))
+}
+
+// downloadPieces downloads every piece of the torrent into storage and
+// returns the number of content bytes written, which leaves out padding.
+// Each source works through a shared queue of pieces at its own pace, so
+// peers and web seeds download side by side. A source that fails is
+// dropped and its piece goes back on the queue for the others. Progress is
+// counted on announcer, if not nil, which re-announces whenever its
+// tracker's interval is up.
+func downloadPieces(torrentInfo TorrentInfo, sources []pieceSource,
+	storage *torrentStorage, announcer *Announcer) (int64, error) {
+	numberOfPieces := torrentInfo.pieceCount()
+	queue := make(chan int, numberOfPieces)
+	for i := 0; i < numberOfPieces; i++ {
+		queue <- i
+	}
+
+	type result struct {
+		index  int
+		data   []byte
+		source pieceSource
+		err    error
+	}
+	results := make(chan result)
+	for _, source := range sources {
+		go func(source pieceSource) {
+			for index := range queue {
+				data, err := source.fetchPiece(torrentInfo, index)
+				results <- result{index: index, data: data, source: source, err: err}
+				if err != nil {
+					return
+				}
+			}
+		}(source)
+	}
+
+	active := len(sources)
+	var downloaded int64
+	for done := 0; done < numberOfPieces; {
+		if active == 0 {
+			return downloaded, fmt.Errorf("every source failed with %d of %d pieces left",
+				numberOfPieces-done, numberOfPieces)
+		}
+		r := <-results
+		if r.err != nil {
+			log.Printf("Dropping %s: %v", r.source, r.err)
+			active--
+			queue <- r.index
+			continue
+		}
+		if _, err := storage.WriteAt(r.data, torrentInfo.pieceOffset(r.index)); err != nil {
+			close(queue)
+			return downloaded, fmt.Errorf("data not written, err- %w", err)
+		}
+		done++
+		wanted := torrentInfo.wantedPieceLength(r.index)
+		downloaded += wanted
+		log.Printf("Piece %d done from %s, %d/%d pieces, %d of %d bytes",
+			r.index, r.source, done, numberOfPieces, downloaded, torrentInfo.wantedLength())
+		if announcer != nil {
+			announcer.AddDownloaded(wanted)
+			if announcer.Due() {
+				if _, err := announcer.Announce(EventNone); err != nil {
+					log.Printf("Unable to announce progress: %v", err)
+				}
+			}
+		}
+	}
+	close(queue)
+	return downloaded, nil
+}
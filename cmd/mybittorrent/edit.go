@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"os"
+	"strings"
+)
+
+// runEdit implements the edit command:
+//
+//	edit [-o out.torrent] [-clear-announce] [-announce url[,url...]]...
+//	     [-clear-web-seeds] [-web-seed url]... [-comment text]
+//	     [-created-by text] [-source text] [-private=true|false] <torrent>
+//
+// Trackers, web seeds, the comment and created by live outside the info
+// dictionary, whose bytes are kept exactly as they are so the info hash
+// doesn't change. -source and -private rewrite the info dictionary and so
+// give the torrent a new info hash. Keys the editor doesn't know about
+// are kept. Without -o the torrent is rewritten in place.
+func runEdit(args []string) error {
+	flags := flag.NewFlagSet("edit", flag.ExitOnError)
+	output := flags.String("o", "", "output path (default: overwrite the input)")
+	clearAnnounce := flags.Bool("clear-announce", false, "remove the existing trackers first")
+	clearWebSeeds := flags.Bool("clear-web-seeds", false, "remove the existing web seeds first")
+	var announce, webSeeds stringList
+	flags.Var(&announce, "announce", "add a tracker tier, comma separated; repeat for more tiers")
+	flags.Var(&webSeeds, "web-seed", "add a web seed URL; repeat for more")
+	comment := flags.String("comment", "", "set the comment; empty removes it")
+	createdBy := flags.String("created-by", "", "set created by; empty removes it")
+	source := flags.String("source", "", "set the source tag; empty removes it (changes the info hash)")
+	private := flags.Bool("private", false, "set or clear the private flag (changes the info hash)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: edit [options] <torrent>")
+	}
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	input := flags.Arg(0)
+	if *output == "" {
+		*output = input
+	}
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	before, err := getTorrentInfo(string(content))
+	if err != nil {
+		return err
+	}
+	metadata, err := bencode.NewDecoder(bytes.NewReader(content)).DecodeDictRaw()
+	if err != nil {
+		return fmt.Errorf("invalid torrent metadata: %w", err)
+	}
+
+	// Fields are only rewritten when asked to, so the rest stay byte for
+	// byte as they were.
+	if *clearAnnounce || len(announce) > 0 {
+		tiers := before.AnnounceList
+		if len(tiers) == 0 && before.Announce != "" {
+			tiers = [][]string{{before.Announce}}
+		}
+		if *clearAnnounce {
+			tiers = nil
+		}
+		for _, tier := range announce {
+			tiers = append(tiers, strings.Split(tier, ","))
+		}
+		if err := setTrackers(metadata, tiers); err != nil {
+			return err
+		}
+	}
+	if *clearWebSeeds || len(webSeeds) > 0 {
+		seeds := before.WebSeeds
+		if *clearWebSeeds {
+			seeds = nil
+		}
+		if err := setRaw(metadata, "url-list", append(seeds, webSeeds...)); err != nil {
+			return err
+		}
+	}
+	if set["comment"] {
+		if err := setRaw(metadata, "comment", *comment); err != nil {
+			return err
+		}
+	}
+	if set["created-by"] {
+		if err := setRaw(metadata, "created by", *createdBy); err != nil {
+			return err
+		}
+	}
+
+	if set["source"] || set["private"] {
+		info, err := bencode.NewDecoder(bytes.NewReader(metadata["info"])).DecodeDictRaw()
+		if err != nil {
+			return fmt.Errorf("invalid torrent info dictionary: %w", err)
+		}
+		if set["source"] {
+			if err := setRaw(info, "source", *source); err != nil {
+				return err
+			}
+		}
+		if set["private"] {
+			// A cleared flag is left out rather than written as 0.
+			if err := setRaw(info, "private", *private); err != nil {
+				return err
+			}
+		}
+		if metadata["info"], err = bencode.Marshal(info); err != nil {
+			return err
+		}
+	}
+
+	edited, err := bencode.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	after, err := getTorrentInfo(string(edited))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, edited, 0644); err != nil {
+		return err
+	}
+
+	fmt.Println("Saved:", *output)
+	if after.InfoHash == before.InfoHash {
+		fmt.Println("Info Hash:", after.InfoHash, "(unchanged)")
+		return nil
+	}
+	fmt.Println("Old Info Hash:", before.InfoHash)
+	fmt.Println("New Info Hash:", after.InfoHash)
+	if after.InfoHashV2 != "" {
+		fmt.Println("New Info Hash v2:", after.InfoHashV2)
+	}
+	return nil
+}
+
+// setTrackers writes tiers as announce and, when there is more than one
+// tracker, announce-list, as create does.
+func setTrackers(metadata map[string]bencode.RawMessage, tiers [][]string) error {
+	var cleaned [][]string
+	for _, tier := range tiers {
+		var urls []string
+		for _, trackerURL := range tier {
+			if trackerURL != "" {
+				urls = append(urls, trackerURL)
+			}
+		}
+		if len(urls) > 0 {
+			cleaned = append(cleaned, urls)
+		}
+	}
+	if len(cleaned) == 0 {
+		delete(metadata, "announce")
+		delete(metadata, "announce-list")
+		return nil
+	}
+	if err := setRaw(metadata, "announce", cleaned[0][0]); err != nil {
+		return err
+	}
+	if len(cleaned) == 1 && len(cleaned[0]) == 1 {
+		delete(metadata, "announce-list")
+		return nil
+	}
+	return setRaw(metadata, "announce-list", cleaned)
+}
+
+// setRaw stores the bencoding of value under key, or removes key when
+// value is empty.
+func setRaw(dict map[string]bencode.RawMessage, key string, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			delete(dict, key)
+			return nil
+		}
+	case bool:
+		if !v {
+			delete(dict, key)
+			return nil
+		}
+	case []string:
+		if len(v) == 0 {
+			delete(dict, key)
+			return nil
+		}
+	}
+	encoded, err := bencode.Marshal(value)
+	if err != nil {
+		return err
+	}
+	dict[key] = encoded
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditRoundTrip(t *testing.T) {
+	// The info dictionary has a key the editor doesn't know, which must
+	// survive every edit.
+	info := "d6:lengthi5e4:name1:x12:piece lengthi16384e6:pieces20:" + strings.Repeat("p", 20) + "4:xtrai1ee"
+	original := "d8:announce17:http://a/announce7:comment3:old4:info" + info + "e"
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.torrent")
+	if err := os.WriteFile(input, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := getTorrentInfo(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		hashChanges bool
+		check       func(TorrentInfo) bool
+	}{
+		{"trackers", []string{"-announce", "http://b/announce,http://c/announce"}, false, func(after TorrentInfo) bool {
+			return reflect.DeepEqual(after.AnnounceList, [][]string{{"http://a/announce"}, {"http://b/announce", "http://c/announce"}})
+		}},
+		{"clear trackers", []string{"-clear-announce"}, false, func(after TorrentInfo) bool {
+			return after.Announce == "" && after.AnnounceList == nil
+		}},
+		{"web seeds", []string{"-web-seed", "http://seed/x"}, false, func(after TorrentInfo) bool {
+			return reflect.DeepEqual(after.WebSeeds, []string{"http://seed/x"})
+		}},
+		{"comment and created by", []string{"-comment", "new", "-created-by", "me"}, false, func(after TorrentInfo) bool {
+			return after.Comment == "new" && after.CreatedBy == "me"
+		}},
+		{"remove comment", []string{"-comment", ""}, false, func(after TorrentInfo) bool {
+			return after.Comment == ""
+		}},
+		{"private", []string{"-private"}, true, func(after TorrentInfo) bool {
+			return after.Private
+		}},
+		{"source", []string{"-source", "site"}, true, func(after TorrentInfo) bool {
+			return bytes.Contains(after.RawInfo, []byte("6:source4:site"))
+		}},
+		// Clearing flags that aren't set rewrites the same info dictionary.
+		{"clear private and source", []string{"-private=false", "-source", ""}, false, func(after TorrentInfo) bool {
+			return !after.Private
+		}},
+	}
+	for _, tt := range tests {
+		output := filepath.Join(dir, "out.torrent")
+		if err := runEdit(append(append([]string{"-o", output}, tt.args...), input)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		edited, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := getTorrentInfo(string(edited))
+		if err != nil {
+			t.Errorf("%s: edited torrent: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(after) {
+			t.Errorf("%s: edit not applied: %s", tt.name, edited)
+		}
+		if !bytes.Contains(after.RawInfo, []byte("4:xtrai1e")) {
+			t.Errorf("%s: unknown info key dropped: %s", tt.name, after.RawInfo)
+		}
+		if hashChanged := after.InfoHash != before.InfoHash; hashChanged != tt.hashChanges {
+			t.Errorf("%s: info hash %s, was %s", tt.name, after.InfoHash, before.InfoHash)
+		}
+		if !tt.hashChanges && string(after.RawInfo) != info {
+			t.Errorf("%s: info bytes %s, want %s", tt.name, after.RawInfo, info)
+		}
+	}
+
+	content, err := os.ReadFile(input)
+	if err != nil || string(content) != original {
+		t.Errorf("input rewritten despite -o: %s, %v", content, err)
+	}
+}
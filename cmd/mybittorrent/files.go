@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TorrentFile is one file of a torrent's content. Offset is where the file
+// starts in the concatenation of all files that pieces are hashed over.
+type TorrentFile struct {
+	Path   []string
+	Length int64
+	Offset int64
+	// PiecesRoot is the root of the file's merkle tree in v2 and hybrid
+	// torrents; empty files have none.
+	PiecesRoot []byte
+
+	// BEP 47 attributes. Padding files only align the next file to a
+	// piece boundary; their content is zeros and never written to disk.
+	Padding    bool
+	Executable bool
+	Hidden     bool
+	// SymlinkPath is the target of a symlink, relative to the torrent root.
+	SymlinkPath []string
+}
+
+type MetadataFile struct {
+	Length      int64    `bencode:"length"`
+	Path        []string `bencode:"path"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+// getFiles lays out the files of info end to end. A single-file torrent
+// becomes one file named after the torrent.
+func getFiles(info MetadataInfo) ([]TorrentFile, int64, error) {
+	if len(info.Files) == 0 {
+		f, err := newTorrentFile([]string{info.Name}, info.Length, info.Attr, info.SymlinkPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []TorrentFile{f}, info.Length, nil
+	}
+	files := make([]TorrentFile, 0, len(info.Files))
+	var offset int64
+	for i, f := range info.Files {
+		if f.Length < 0 {
+			return nil, 0, fmt.Errorf("file %d has negative length %d", i, f.Length)
+		}
+		file, err := newTorrentFile(f.Path, f.Length, f.Attr, f.SymlinkPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		file.Offset = offset
+		files = append(files, file)
+		offset += f.Length
+	}
+	return files, offset, nil
+}
+
+// newTorrentFile returns a file with the BEP 47 attributes in attr. Files
+// under .pad are padding even without the p attribute, as older clients
+// wrote them.
+func newTorrentFile(path []string, length int64, attr string, symlinkPath []string) (TorrentFile, error) {
+	f := TorrentFile{
+		Path:       path,
+		Length:     length,
+		Padding:    strings.ContainsRune(attr, 'p') || (len(path) > 1 && path[0] == ".pad"),
+		Executable: strings.ContainsRune(attr, 'x'),
+		Hidden:     strings.ContainsRune(attr, 'h'),
+	}
+	if strings.ContainsRune(attr, 'l') {
+		if len(symlinkPath) == 0 {
+			return TorrentFile{}, fmt.Errorf("symlink %q has no symlink path", strings.Join(path, "/"))
+		}
+		f.SymlinkPath = symlinkPath
+	}
+	return f, nil
+}
+
+// wantedLength returns the size of the content that is written to disk,
+// which leaves out padding files.
+func (t TorrentInfo) wantedLength() int64 {
+	var length int64
+	for _, f := range t.Files {
+		if !f.Padding {
+			length += f.Length
+		}
+	}
+	return length
+}
+
+// wantedPieceLength returns how much of piece index is content rather than
+// padding, which is what counts as downloaded.
+func (t TorrentInfo) wantedPieceLength(index int) int64 {
+	start := t.pieceOffset(index)
+	end := start + t.pieceLength(index)
+	length := end - start
+	for _, f := range t.Files {
+		if !f.Padding || f.Offset >= end || f.Offset+f.Length <= start {
+			continue
+		}
+		overlapStart, overlapEnd := f.Offset, f.Offset+f.Length
+		if overlapStart < start {
+			overlapStart = start
+		}
+		if overlapEnd > end {
+			overlapEnd = end
+		}
+		length -= overlapEnd - overlapStart
+	}
+	return length
+}
+
+// localPath maps a torrent file path onto the local filesystem under root.
+// Components that could escape root are rejected.
+func localPath(root string, path []string) (string, error) {
+	if len(path) == 0 {
+		return "", fmt.Errorf("empty file path")
+	}
+	parts := []string{root}
+	for _, component := range path {
+		if component == "" || component == "." || component == ".." ||
+			strings.ContainsAny(component, `/\`) {
+			return "", fmt.Errorf("unsafe path component %q in %q", component, strings.Join(path, "/"))
+		}
+		parts = append(parts, component)
+	}
+	return filepath.Join(parts...), nil
+}
+
+// torrentStorage writes torrent content, addressed by its offset in the
+// concatenated file list, into the files it belongs to.
+type torrentStorage struct {
+	files []storageFile
+}
+
+type storageFile struct {
+	path   string
+	length int64
+	offset int64
+}
+
+// newTorrentStorage creates every file of the torrent at its full length.
+// For a single-file torrent output is the file itself; for a multi-file
+// torrent it is the directory the file tree is written into.
+func newTorrentStorage(output string, torrentInfo TorrentInfo) (*torrentStorage, error) {
+	storage := &torrentStorage{}
+	for _, f := range torrentInfo.Files {
+		// Padding is dropped and symlinks are made once the download is
+		// complete.
+		if f.Padding || f.SymlinkPath != nil {
+			continue
+		}
+		path := output
+		if torrentInfo.MultiFile {
+			var err error
+			if path, err = localPath(output, f.Path); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		if err != nil {
+			return nil, err
+		}
+		err = file.Truncate(f.Length)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		storage.files = append(storage.files, storageFile{path: path, length: f.Length, offset: f.Offset})
+	}
+	return storage, nil
+}
+
+// applyFileAttributes makes the symlinks of a downloaded torrent and marks
+// its executable files, in the tree newTorrentStorage created at output.
+func applyFileAttributes(output string, torrentInfo TorrentInfo) error {
+	root := output
+	if !torrentInfo.MultiFile {
+		root = filepath.Dir(output)
+	}
+	for _, f := range torrentInfo.Files {
+		if f.Padding {
+			continue
+		}
+		path := output
+		if torrentInfo.MultiFile {
+			var err error
+			if path, err = localPath(output, f.Path); err != nil {
+				return err
+			}
+		}
+		switch {
+		case f.SymlinkPath != nil:
+			target, err := localPath(root, f.SymlinkPath)
+			if err != nil {
+				return err
+			}
+			relative, err := filepath.Rel(filepath.Dir(path), target)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(relative, path); err != nil {
+				return err
+			}
+		case f.Executable:
+			stat, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(path, stat.Mode()|0111); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteAt writes p at content offset off, splitting it across every file
+// the range overlaps.
+func (s *torrentStorage) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	for _, f := range s.files {
+		end := off + int64(len(p))
+		if f.length == 0 || f.offset+f.length <= off || f.offset >= end {
+			continue
+		}
+		start := off
+		if start < f.offset {
+			start = f.offset
+		}
+		stop := end
+		if stop > f.offset+f.length {
+			stop = f.offset + f.length
+		}
+		file, err := os.OpenFile(f.path, os.O_WRONLY, 0)
+		if err != nil {
+			return written, err
+		}
+		n, err := file.WriteAt(p[start-off:stop-off], start-f.offset)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
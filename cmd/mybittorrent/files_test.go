@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageWriteAt(t *testing.T) {
+	output := t.TempDir()
+	files := []TorrentFile{
+		{Path: []string{"a"}, Length: 5},
+		{Path: []string{"empty"}, Length: 0},
+		{Path: []string{".pad", "3"}, Length: 3, Padding: true},
+		{Path: []string{"sub", "b"}, Length: 4},
+		{Path: []string{"c"}, Length: 6},
+	}
+	var offset int64
+	for i := range files {
+		files[i].Offset = offset
+		offset += files[i].Length
+	}
+	storage, err := newTorrentStorage(output, TorrentInfo{Files: files, TotalLength: offset, MultiFile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One write from the middle of a, over the empty file, the padding and
+	// all of b, into the middle of c.
+	data := []byte("0123456789abcdef")
+	n, err := storage.WriteAt(data[2:16], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 {
+		t.Errorf("wrote %d bytes, want the 11 that are not padding", n)
+	}
+	for _, tt := range []struct {
+		path string
+		want []byte
+	}{
+		{"a", []byte("\x00\x00234")},
+		{"empty", nil},
+		{"sub/b", []byte("89ab")},
+		{"c", []byte("cdef\x00\x00")},
+	} {
+		got, err := os.ReadFile(filepath.Join(output, filepath.FromSlash(tt.path)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(output, ".pad")); !os.IsNotExist(err) {
+		t.Errorf("padding written to disk: %v", err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	root := filepath.Join("out", "dir")
+	got, err := localPath(root, []string{"sub", "file.txt"})
+	if want := filepath.Join(root, "sub", "file.txt"); err != nil || got != want {
+		t.Errorf("localPath = %q, %v; want %q", got, err, want)
+	}
+	for _, path := range [][]string{
+		nil,
+		{""},
+		{"sub", ""},
+		{"."},
+		{".."},
+		{"sub", "..", "..", "etc"},
+		{"a/b"},
+		{`a\b`},
+		{"/etc"},
+	} {
+		if got, err := localPath(root, path); err == nil {
+			t.Errorf("localPath(%q) = %q, want an error", path, got)
+		}
+	}
+}
+
+func TestWantedLength(t *testing.T) {
+	// Pieces of 8 bytes: the first ends in a padding file, the second is
+	// a padding file that only .pad/ marks, and the third is short.
+	files, total, err := getFiles(MetadataInfo{Files: []MetadataFile{
+		{Length: 5, Path: []string{"a"}},
+		{Length: 3, Path: []string{"pad"}, Attr: "p"},
+		{Length: 8, Path: []string{".pad", "8"}},
+		{Length: 4, Path: []string{"b"}, Attr: "x"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, padding := range []bool{false, true, true, false} {
+		if files[i].Padding != padding {
+			t.Errorf("file %q padding = %v, want %v", files[i].Path, files[i].Padding, padding)
+		}
+	}
+	torrentInfo := TorrentInfo{PieceLength: 8, Pieces: make([]string, 3), TotalLength: total, Files: files}
+	for index, want := range []int64{5, 0, 4} {
+		if got := torrentInfo.wantedPieceLength(index); got != want {
+			t.Errorf("wantedPieceLength(%d) = %d, want %d", index, got, want)
+		}
+	}
+	if got := torrentInfo.wantedLength(); got != 9 {
+		t.Errorf("wantedLength = %d, want 9", got)
+	}
+}
+
+func TestNewTorrentFileAttributes(t *testing.T) {
+	// A file named .pad at the top level is content, not padding.
+	if f, err := newTorrentFile([]string{".pad"}, 3, "", nil); err != nil || f.Padding {
+		t.Errorf(".pad file = %+v, %v; want content", f, err)
+	}
+	f, err := newTorrentFile([]string{"link"}, 0, "l", []string{"dir", "target"})
+	if err != nil || len(f.SymlinkPath) != 2 {
+		t.Errorf("symlink = %+v, %v", f, err)
+	}
+	if _, err := newTorrentFile([]string{"link"}, 0, "l", nil); err == nil {
+		t.Error("symlink without a symlink path accepted")
+	}
+	if _, _, err := getFiles(MetadataInfo{Name: "link", Attr: "l"}); err == nil {
+		t.Error("single-file symlink without a symlink path accepted")
+	}
+}
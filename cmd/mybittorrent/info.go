@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"io"
+	"net"
+	"strconv"
+)
+
+// torrentInfoJSON is the output of `info --json`.
+type torrentInfoJSON struct {
+	Name         string         `json:"name"`
+	TotalLength  int64          `json:"total_length"`
+	WantedLength int64          `json:"wanted_length"`
+	PieceLength  int64          `json:"piece_length"`
+	PieceCount   int            `json:"piece_count"`
+	MetaVersion  int64          `json:"meta_version"`
+	InfoHash     string         `json:"info_hash"`
+	InfoHashV2   string         `json:"info_hash_v2,omitempty"`
+	Announce     string         `json:"announce,omitempty"`
+	AnnounceList [][]string     `json:"announce_list,omitempty"`
+	Comment      string         `json:"comment,omitempty"`
+	CreatedBy    string         `json:"created_by,omitempty"`
+	CreationDate int64          `json:"creation_date,omitempty"`
+	Encoding     string         `json:"encoding,omitempty"`
+	Private      bool           `json:"private"`
+	MultiFile    bool           `json:"multi_file"`
+	Files        []fileInfoJSON `json:"files"`
+	PieceHashes  []string       `json:"piece_hashes,omitempty"`
+	WebSeeds     []string       `json:"web_seeds,omitempty"`
+	Nodes        []string       `json:"nodes,omitempty"`
+}
+
+type fileInfoJSON struct {
+	Path       []string `json:"path"`
+	Length     int64    `json:"length"`
+	Offset     int64    `json:"offset"`
+	PiecesRoot string   `json:"pieces_root,omitempty"`
+	Padding    bool     `json:"padding,omitempty"`
+	Executable bool     `json:"executable,omitempty"`
+	Hidden     bool     `json:"hidden,omitempty"`
+	Symlink    []string `json:"symlink,omitempty"`
+}
+
+// printTorrentInfoJSON writes everything known about the torrent as one
+// JSON object. InfoHash is the 20-byte hash peers and trackers use; for a
+// v2 torrent InfoHashV2 is the full SHA-256 hash.
+func printTorrentInfoJSON(w io.Writer, torrentInfo TorrentInfo) error {
+	output := torrentInfoJSON{
+		Name:         torrentInfo.Name,
+		TotalLength:  torrentInfo.TotalLength,
+		WantedLength: torrentInfo.wantedLength(),
+		PieceLength:  torrentInfo.PieceLength,
+		PieceCount:   torrentInfo.pieceCount(),
+		MetaVersion:  torrentInfo.MetaVersion,
+		InfoHash:     torrentInfo.InfoHash,
+		InfoHashV2:   torrentInfo.InfoHashV2,
+		Announce:     torrentInfo.Announce,
+		AnnounceList: torrentInfo.AnnounceList,
+		Comment:      torrentInfo.Comment,
+		CreatedBy:    torrentInfo.CreatedBy,
+		CreationDate: torrentInfo.CreationDate,
+		Encoding:     torrentInfo.Encoding,
+		Private:      torrentInfo.Private,
+		MultiFile:    torrentInfo.MultiFile,
+		Files:        []fileInfoJSON{},
+		PieceHashes:  torrentInfo.Pieces,
+		WebSeeds:     torrentInfo.WebSeeds,
+		Nodes:        torrentInfo.Nodes,
+	}
+	for _, f := range torrentInfo.Files {
+		output.Files = append(output.Files, fileInfoJSON{
+			Path:       f.Path,
+			Length:     f.Length,
+			Offset:     f.Offset,
+			PiecesRoot: fmt.Sprintf("%x", f.PiecesRoot),
+			Padding:    f.Padding,
+			Executable: f.Executable,
+			Hidden:     f.Hidden,
+			Symlink:    f.SymlinkPath,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// getNodes formats the [host, port] pairs of a nodes list as host:port,
+// skipping malformed entries. Some torrents list nodes as "host:port"
+// strings instead, which are accepted too.
+func getNodes(raw bencode.RawMessage) []string {
+	var nodes []interface{}
+	if len(raw) == 0 || bencode.Unmarshal(raw, &nodes) != nil {
+		return nil
+	}
+	var addresses []string
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case []byte:
+			host, port, err := net.SplitHostPort(string(node))
+			if err != nil || host == "" || !validPort(port) {
+				continue
+			}
+			addresses = append(addresses, net.JoinHostPort(host, port))
+		case []interface{}:
+			if len(node) != 2 {
+				continue
+			}
+			host, ok := node[0].([]byte)
+			port, portOk := node[1].(int64)
+			if !ok || !portOk || len(host) == 0 || port <= 0 || port > 65535 {
+				continue
+			}
+			addresses = append(addresses, net.JoinHostPort(string(host), strconv.FormatInt(port, 10)))
+		}
+	}
+	return addresses
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
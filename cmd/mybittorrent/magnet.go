@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Magnet is a parsed magnet URI (BEP 9, with the BEP 53 and BEP 52
+// extensions).
+type Magnet struct {
+	// InfoHash is the 20-byte v1 info hash from xt=urn:btih.
+	InfoHash []byte
+	// InfoHashV2 is the 32-byte SHA-256 v2 info hash from xt=urn:btmh.
+	InfoHashV2  []byte
+	DisplayName string
+	Trackers    []string
+	// Peers are host:port addresses from x.pe.
+	Peers    []string
+	WebSeeds []string
+	// SelectOnly lists the file indexes from so, with ranges expanded.
+	SelectOnly []int
+}
+
+// sha256Multihash is the multihash prefix for a 32-byte SHA2-256 digest, the
+// only form btmh takes for BitTorrent v2.
+var sha256Multihash = []byte{0x12, 0x20}
+
+// parseMagnet parses a magnet URI. It must carry at least one info hash.
+func parseMagnet(uri string) (Magnet, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return Magnet{}, fmt.Errorf("invalid magnet link: %w", err)
+	}
+	if parsed.Scheme != "magnet" {
+		return Magnet{}, fmt.Errorf("invalid magnet link: scheme is %q, not magnet", parsed.Scheme)
+	}
+	query, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return Magnet{}, fmt.Errorf("invalid magnet link: %w", err)
+	}
+
+	// Visit keys in order so numbered parameters keep their order: tr,
+	// then tr.1, tr.2 and on to tr.10.
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		nameI, numberI := magnetParameter(keys[i])
+		nameJ, numberJ := magnetParameter(keys[j])
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		if numberI != numberJ {
+			return numberI < numberJ
+		}
+		return keys[i] < keys[j]
+	})
+
+	magnet := Magnet{}
+	for _, key := range keys {
+		values := query[key]
+		name, _ := magnetParameter(key)
+		for _, value := range values {
+			switch name {
+			case "xt":
+				if err := magnet.setExactTopic(value); err != nil {
+					return Magnet{}, err
+				}
+			case "dn":
+				magnet.DisplayName = value
+			case "tr":
+				magnet.Trackers = append(magnet.Trackers, value)
+			case "x.pe":
+				magnet.Peers = append(magnet.Peers, value)
+			case "ws":
+				magnet.WebSeeds = append(magnet.WebSeeds, value)
+			case "so":
+				selection, err := parseFileSelection(value)
+				if err != nil {
+					return Magnet{}, err
+				}
+				magnet.SelectOnly = append(magnet.SelectOnly, selection...)
+			}
+		}
+	}
+	if magnet.InfoHash == nil && magnet.InfoHashV2 == nil {
+		return Magnet{}, fmt.Errorf("invalid magnet link: no urn:btih or urn:btmh exact topic")
+	}
+	return magnet, nil
+}
+
+// magnetParameter splits a magnet query key into the parameter it gives
+// and its number. Parameters may be numbered to give several of them, as
+// in xt.1; an unnumbered key gets -1.
+func magnetParameter(key string) (string, int) {
+	if dot := strings.LastIndex(key, "."); dot > 0 {
+		if number, err := strconv.Atoi(key[dot+1:]); err == nil && number >= 0 {
+			return key[:dot], number
+		}
+	}
+	return key, -1
+}
+
+func (m *Magnet) setExactTopic(topic string) error {
+	switch {
+	case strings.HasPrefix(topic, "urn:btih:"):
+		hash := topic[len("urn:btih:"):]
+		var infoHash []byte
+		var err error
+		switch len(hash) {
+		case 40:
+			infoHash, err = hex.DecodeString(hash)
+		case 32:
+			infoHash, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("length %d is neither 40 hex nor 32 base32 characters", len(hash))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid btih info hash %q: %w", hash, err)
+		}
+		m.InfoHash = infoHash
+	case strings.HasPrefix(topic, "urn:btmh:"):
+		hash := topic[len("urn:btmh:"):]
+		multihash, err := hex.DecodeString(hash)
+		if err != nil {
+			return fmt.Errorf("invalid btmh info hash %q: %w", hash, err)
+		}
+		if len(multihash) != 34 || multihash[0] != sha256Multihash[0] || multihash[1] != sha256Multihash[1] {
+			return fmt.Errorf("invalid btmh info hash %q: not a SHA2-256 multihash", hash)
+		}
+		m.InfoHashV2 = multihash[2:]
+	}
+	// Other URNs (ed2k, sha1 and so on) aren't BitTorrent hashes; skip them.
+	return nil
+}
+
+// maxFileSelection caps how many indexes one so range may expand to.
+const maxFileSelection = 1 << 16
+
+// parseFileSelection parses a BEP 53 so value such as "0,2,4-6".
+func parseFileSelection(value string) ([]int, error) {
+	var indexes []int
+	for _, part := range strings.Split(value, ",") {
+		first, last := part, part
+		if dash := strings.Index(part, "-"); dash >= 0 {
+			first, last = part[:dash], part[dash+1:]
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid so file selection %q", value)
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from || to-from >= maxFileSelection {
+			return nil, fmt.Errorf("invalid so file selection %q", value)
+		}
+		for i := from; i <= to; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
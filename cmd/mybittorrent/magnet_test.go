@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	v1Hex := "c9e15763f722f23e98a29decdfae341b98d53056"
+	v1, _ := hex.DecodeString(v1Hex)
+	v1Base32 := strings.ToLower(base32.StdEncoding.EncodeToString(v1))
+	v2 := bytes.Repeat([]byte{0xab}, 32)
+	v2Multihash := "1220" + hex.EncodeToString(v2)
+
+	tests := []struct {
+		name string
+		uri  string
+		want Magnet
+	}{
+		{"hex btih", "magnet:?xt=urn:btih:" + v1Hex, Magnet{InfoHash: v1}},
+		{"upper case hex btih", "magnet:?xt=urn:btih:" + strings.ToUpper(v1Hex), Magnet{InfoHash: v1}},
+		{"base32 btih", "magnet:?xt=urn:btih:" + v1Base32, Magnet{InfoHash: v1}},
+		{"btmh", "magnet:?xt=urn:btmh:" + v2Multihash, Magnet{InfoHashV2: v2}},
+		{"hybrid", "magnet:?xt=urn:btih:" + v1Hex + "&xt=urn:btmh:" + v2Multihash,
+			Magnet{InfoHash: v1, InfoHashV2: v2}},
+		{"numbered hybrid", "magnet:?xt.1=urn:btmh:" + v2Multihash + "&xt.2=urn:btih:" + v1Hex,
+			Magnet{InfoHash: v1, InfoHashV2: v2}},
+		{"other urns skipped", "magnet:?xt=urn:ed2k:abc&xt=urn:btih:" + v1Hex, Magnet{InfoHash: v1}},
+		{"display name", "magnet:?xt=urn:btih:" + v1Hex + "&dn=Some+File%20Name", Magnet{InfoHash: v1, DisplayName: "Some File Name"}},
+		{"repeated tr keeps order", "magnet:?xt=urn:btih:" + v1Hex + "&tr=http%3A%2F%2Fb%2Fannounce&tr=udp://a:80",
+			Magnet{InfoHash: v1, Trackers: []string{"http://b/announce", "udp://a:80"}}},
+		{"numbered tr in numeric order",
+			"magnet:?xt=urn:btih:" + v1Hex + "&tr.10=http://ten&tr.2=http://two&tr.1=http://one&tr=http://plain",
+			Magnet{InfoHash: v1, Trackers: []string{"http://plain", "http://one", "http://two", "http://ten"}}},
+		{"peers", "magnet:?xt=urn:btih:" + v1Hex + "&x.pe=10.0.0.1:6881&x.pe=%5B::1%5D:51413",
+			Magnet{InfoHash: v1, Peers: []string{"10.0.0.1:6881", "[::1]:51413"}}},
+		{"web seeds", "magnet:?xt=urn:btih:" + v1Hex + "&ws=http://seed/file",
+			Magnet{InfoHash: v1, WebSeeds: []string{"http://seed/file"}}},
+		{"select only", "magnet:?xt=urn:btih:" + v1Hex + "&so=0,2,4-6,9-9",
+			Magnet{InfoHash: v1, SelectOnly: []int{0, 2, 4, 5, 6, 9}}},
+		{"unknown parameters ignored", "magnet:?xt=urn:btih:" + v1Hex + "&xl=1234&kt=word",
+			Magnet{InfoHash: v1}},
+	}
+	for _, tt := range tests {
+		got, err := parseMagnet(tt.uri)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseMagnet = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseMagnetInvalid(t *testing.T) {
+	v1Hex := "c9e15763f722f23e98a29decdfae341b98d53056"
+	for _, uri := range []string{
+		"",
+		"http://example.com/?xt=urn:btih:" + v1Hex,
+		"magnet:?dn=no+hash",
+		"magnet:?xt=urn:ed2k:abc",
+		"magnet:?xt=urn:btih:" + v1Hex[:39],
+		"magnet:?xt=urn:btih:" + v1Hex[:38] + "zz",
+		"magnet:?xt=urn:btih:" + strings.Repeat("1", 32),
+		"magnet:?xt=urn:btmh:1220" + v1Hex,
+		"magnet:?xt=urn:btmh:1114" + strings.Repeat("ab", 32),
+		"magnet:?xt=urn:btmh:not-hex",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=3-1",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=a",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=-1",
+		"magnet:?xt=urn:btih:" + v1Hex + "&so=0-99999999",
+		"magnet:?xt=urn:btih:" + v1Hex + "&dn=%zz",
+	} {
+		if magnet, err := parseMagnet(uri); err == nil {
+			t.Errorf("parseMagnet(%q) = %+v, want an error", uri, magnet)
+		}
+	}
+}
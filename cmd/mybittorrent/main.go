@@ -1,130 +1,286 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha1"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
-	bencode "github.com/jackpal/bencode-go"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"net"
-	"net/http"
-	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
+	"unicode/utf8"
 )
 
 func decodeBencoded(bencodedString string) (interface{}, error) {
-	decoded, err := bencode.Decode(strings.NewReader(bencodedString))
-	if err != nil {
-		return nil, fmt.Errorf("Only strings,ints, lists are supported at the moment, %q", bencodedString)
+	return bencode.DecodeString(bencodedString)
+}
+
+// Tagged JSON objects used by `decode --binary` for values plain JSON can't
+// carry. bencodeValueFromJSON turns them back into the original bencode.
+//   - {"$hex": "..."} and {"$base64": "..."} hold a byte string that is not
+//     valid UTF-8.
+//   - {"$dict": [[key, value], ...]} holds a dictionary whose keys aren't
+//     all valid UTF-8, or whose only key is one of these tags.
+const (
+	jsonTagHex    = "$hex"
+	jsonTagBase64 = "$base64"
+	jsonTagDict   = "$dict"
+)
+
+// jsonValue converts a decoded bencode value into something json.Marshal
+// can render. With binary set to "" byte strings become Go strings as is;
+// with "hex" or "base64" byte strings that aren't valid UTF-8 become tagged
+// objects in that encoding, so the output round-trips through `encode`.
+func jsonValue(v interface{}, binary string) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return jsonString(v, binary)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = jsonValue(item, binary)
+		}
+		return list
+	case map[string]interface{}:
+		if binary != "" && !plainJSONKeys(v) {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			pairs := make([]interface{}, len(keys))
+			for i, k := range keys {
+				pairs[i] = []interface{}{jsonString([]byte(k), binary), jsonValue(v[k], binary)}
+			}
+			return map[string]interface{}{jsonTagDict: pairs}
+		}
+		dict := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			dict[k] = jsonValue(item, binary)
+		}
+		return dict
+	default:
+		return v
 	}
-	return decoded, nil
 }
 
-// Example:
-// - 5:hello -> hello
-// - 10:hello12345 -> hello12345
-func decodeBencode(bencodedString string) (interface{}, int, error) {
-	if len(bencodedString) == 0 {
-		return nil, 1, nil
+func jsonString(s []byte, binary string) interface{} {
+	if binary == "" || utf8.Valid(s) {
+		return string(s)
+	}
+	if binary == "hex" {
+		return map[string]string{jsonTagHex: hex.EncodeToString(s)}
 	}
-	if unicode.IsDigit(rune(bencodedString[0])) {
-		var firstColonIndex int
+	return map[string]string{jsonTagBase64: base64.StdEncoding.EncodeToString(s)}
+}
 
-		for i := 0; i < len(bencodedString); i++ {
-			if bencodedString[i] == ':' {
-				firstColonIndex = i
-				break
-			}
+// plainJSONKeys reports whether dict can be written as a JSON object
+// without being mistaken for a tagged value or losing key bytes.
+func plainJSONKeys(dict map[string]interface{}) bool {
+	for k := range dict {
+		if !utf8.ValidString(k) {
+			return false
 		}
+		if len(dict) == 1 && (k == jsonTagHex || k == jsonTagBase64 || k == jsonTagDict) {
+			return false
+		}
+	}
+	return true
+}
 
-		lengthStr := bencodedString[:firstColonIndex]
+// bencodeValueFromJSON parses JSON into values the bencode encoder accepts:
+// strings become byte strings, numbers must be integers, and arrays and
+// objects become lists and dictionaries. Tagged objects produced by
+// `decode --binary` are converted back to the bytes they stand for.
+func bencodeValueFromJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return bencodeValue(value)
+}
 
-		length, err := strconv.Atoi(lengthStr)
+func bencodeValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case json.Number:
+		n, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
-			return "", 1, err
+			return nil, fmt.Errorf("bencode only supports integers, got %v", v)
 		}
-
-		return bencodedString[firstColonIndex+1 : firstColonIndex+1+length], firstColonIndex + length, nil
-	} else if bencodedString[0] == 'i' {
-		var firstEIndex int
-		for i := 0; i < len(bencodedString); i++ {
-			if bencodedString[i] == 'e' {
-				firstEIndex = i
-				break
+		return n, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := bencodeValue(item)
+			if err != nil {
+				return nil, err
 			}
+			list[i] = value
 		}
-		intStr := bencodedString[1:firstEIndex]
-		intValue, err := strconv.Atoi(intStr)
-		if err != nil {
-			return "", 1, err
-		}
-
-		return intValue, firstEIndex, nil
-	} else if bencodedString[0] == 'l' {
-		list, lastIndexCovered, err := decodeBencodedList(bencodedString[1:])
-		return list, lastIndexCovered - 1, err
-	} else if bencodedString[0] == 'd' {
-		dict := make(map[string]interface{}, 0)
-		indx := 1
-		for bencodedString[indx] != 'e' && len(bencodedString[indx:]) != 1 {
-			k, KeyLastIndexCovered, err := decodeBencode(bencodedString[indx:])
+		return list, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if value, ok, err := taggedBencodeValue(v); ok || err != nil {
+				return value, err
+			}
+		}
+		dict := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			value, err := bencodeValue(item)
 			if err != nil {
-				return invalidDecodeType(bencodedString[indx:])
+				return nil, err
+			}
+			dict[k] = value
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("bencode has no representation for JSON value %v", v)
+	}
+}
+
+// taggedBencodeValue decodes a single-key object if its key is one of the
+// jsonTag constants. ok is false for any other object.
+func taggedBencodeValue(object map[string]interface{}) (value interface{}, ok bool, err error) {
+	for tag, content := range object {
+		switch tag {
+		case jsonTagHex, jsonTagBase64:
+			text, isString := content.(string)
+			if !isString {
+				return nil, true, fmt.Errorf("%s value must be a string", tag)
 			}
-			var key string
-			if k, ok := k.(string); !ok {
-				return "", KeyLastIndexCovered, fmt.Errorf("expected string key but got %q", k)
+			var decoded []byte
+			if tag == jsonTagHex {
+				decoded, err = hex.DecodeString(text)
 			} else {
-				key = k
+				decoded, err = base64.StdEncoding.DecodeString(text)
 			}
-			indx += KeyLastIndexCovered + 1
-			v, valueLastIndexCovered, err := decodeBencode(bencodedString[indx:])
 			if err != nil {
-				return invalidDecodeType(bencodedString[indx:])
+				return nil, true, fmt.Errorf("invalid %s value: %w", tag, err)
+			}
+			return decoded, true, nil
+		case jsonTagDict:
+			pairs, isList := content.([]interface{})
+			if !isList {
+				return nil, true, fmt.Errorf("%s value must be a list of [key, value] pairs", tag)
 			}
-			indx += valueLastIndexCovered + 1
-			dict[key] = v
+			dict := make(map[string]interface{}, len(pairs))
+			for _, pair := range pairs {
+				kv, isPair := pair.([]interface{})
+				if !isPair || len(kv) != 2 {
+					return nil, true, fmt.Errorf("%s value must be a list of [key, value] pairs", tag)
+				}
+				key, err := bencodeValue(kv[0])
+				if err != nil {
+					return nil, true, err
+				}
+				keyBytes, isBytes := key.([]byte)
+				if !isBytes {
+					return nil, true, fmt.Errorf("%s keys must be strings", tag)
+				}
+				item, err := bencodeValue(kv[1])
+				if err != nil {
+					return nil, true, err
+				}
+				dict[string(keyBytes)] = item
+			}
+			return dict, true, nil
 		}
-		return dict, indx, nil
-	} else if bencodedString[0] == 'e' {
-		return decodeBencode(bencodedString[1:])
-	} else {
-		return invalidDecodeType(bencodedString)
 	}
+	return nil, false, nil
 }
 
-func invalidDecodeType(bencodedString string) (interface{}, int, error) {
-	return "", 1, fmt.Errorf("Only strings,ints, lists are supported at the moment, %q", bencodedString)
+// commandInput returns a command's input argument, or all of stdin when
+// the argument is "-". Torrent files can contain NUL bytes, which can't be
+// passed on the command line.
+func commandInput(arg string) []byte {
+	if arg != "-" {
+		return []byte(arg)
+	}
+	stdin, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return stdin
 }
 
-func decodeBencodedList(bencodedString string) (interface{}, int, error) {
-	list := []interface{}{}
-	i := 0
-	for i < len(bencodedString) {
-		decodedValue, lastIndexCovered, err := decodeBencode(bencodedString[i:])
-		if err != nil {
-			return "", lastIndexCovered, err
+// printBencodeTree writes node and its children to w, one value per line,
+// indented by depth. Each line gives the value's type, size and byte span
+// [start:end) in the input.
+func printBencodeTree(w io.Writer, node *bencode.Node, label string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	key := label
+	if label != "" {
+		label += ": "
+	}
+	span := fmt.Sprintf("[%d:%d]", node.Start, node.End)
+	switch node.Kind {
+	case bencode.KindString:
+		fmt.Fprintf(w, "%s%sstring, %d bytes, %s %s\n",
+			indent, label, len(node.String), summarizeBytes(key, node.String), span)
+	case bencode.KindInteger:
+		fmt.Fprintf(w, "%s%sint %d %s\n", indent, label, node.Integer, span)
+	case bencode.KindList:
+		fmt.Fprintf(w, "%s%slist, %d items %s\n", indent, label, len(node.List), span)
+		for i, item := range node.List {
+			printBencodeTree(w, item, fmt.Sprintf("[%d]", i), depth+1)
 		}
-		if decodedValue != nil {
-			list = append(list, decodedValue)
+	case bencode.KindDict:
+		fmt.Fprintf(w, "%s%sdict, %d entries %s\n", indent, label, len(node.Dict), span)
+		for _, entry := range node.Dict {
+			key := string(entry.Key)
+			if !utf8.Valid(entry.Key) {
+				key = "0x" + hex.EncodeToString(entry.Key)
+			}
+			printBencodeTree(w, entry.Value, key, depth+1)
 		}
-		i += lastIndexCovered + 1
-		for i < len(bencodedString) && bencodedString[i] == 'e' {
-			i++
+	}
+}
+
+// summarizeBytes describes the byte string under key for
+// printBencodeTree: text is quoted, shortened if long; binary data is
+// described by shape instead. Only a pieces value is taken to be SHA-1
+// hashes, as other binary strings can be multiples of 20 bytes too.
+func summarizeBytes(key string, s []byte) string {
+	const maxText = 60
+	if utf8.Valid(s) && !containsControl(s) {
+		if len(s) > maxText {
+			return strconv.Quote(string(s[:maxText])) + "..."
+		}
+		return strconv.Quote(string(s))
+	}
+	if key == "pieces" && len(s)%sha1.Size == 0 {
+		return fmt.Sprintf("binary, %d SHA-1 hashes", len(s)/sha1.Size)
+	}
+	const maxPreview = 16
+	if len(s) > maxPreview {
+		return fmt.Sprintf("binary, starts %x...", s[:maxPreview])
+	}
+	return fmt.Sprintf("binary, %x", s)
+}
+
+func containsControl(s []byte) bool {
+	for _, c := range s {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return true
 		}
 	}
-	return list, i - 1, nil
+	return false
 }
 
 func main() {
@@ -134,21 +290,73 @@ func main() {
 	command := os.Args[1]
 
 	if command == "decode" {
-		// Uncomment this block to pass the first stage
+		flags := flag.NewFlagSet("decode", flag.ExitOnError)
+		strict := flags.Bool("strict", false, "reject input that is not canonical BEP 3 bencoding")
+		binary := flags.String("binary", "", "render non-UTF-8 byte strings as tagged objects: hex or base64")
+		tree := flags.Bool("tree", false, "print the structure with the byte offsets of every value")
+		flags.Parse(os.Args[2:])
+		if *binary != "" && *binary != "hex" && *binary != "base64" {
+			fmt.Println("--binary must be hex or base64")
+			os.Exit(1)
+		}
 
-		bencodedValue := os.Args[2]
+		// The value is given inline, or read from stdin when it is "-".
+		bencodedValue := string(commandInput(flags.Arg(0)))
 
-		decoded, err := decodeBencoded(bencodedValue)
+		if *tree {
+			decoder := bencode.NewDecoder(strings.NewReader(bencodedValue))
+			if *strict {
+				decoder.UseStrict()
+			}
+			node, err := decoder.DecodeNode()
+			if err == nil && *strict {
+				err = decoder.ExpectEnd()
+			}
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			printBencodeTree(os.Stdout, node, "", 0)
+			return
+		}
+
+		var decoded interface{}
+		var err error
+		if *strict {
+			decoded, err = bencode.DecodeBytesStrict([]byte(bencodedValue))
+		} else {
+			decoded, err = decodeBencoded(bencodedValue)
+		}
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		jsonOutput, _ := json.Marshal(decoded)
+		jsonOutput, _ := json.Marshal(jsonValue(decoded, *binary))
 		fmt.Println(string(jsonOutput))
+	} else if command == "encode" {
+		// The value is JSON given inline, or read from stdin when it is "-".
+		input := commandInput(os.Args[2])
+
+		value, err := bencodeValueFromJSON(input)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		encoded, err := bencode.EncodeBytes(value)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(encoded)
 	} else if command == "info" {
+		flags := flag.NewFlagSet("info", flag.ExitOnError)
+		jsonOutput := flags.Bool("json", false, "print the whole metainfo as JSON")
+		strict := flags.Bool("strict", false, "reject metainfo that is not canonical BEP 3 bencoding")
+		flags.Parse(os.Args[2:])
+
 		// Get the path to the file from the command-line argument.
-		filePath := os.Args[2]
+		filePath := flags.Arg(0)
 
 		// Read the entire contents of the file into a string.
 		content, err := os.ReadFile(filePath)
@@ -162,19 +370,17 @@ func main() {
 		// Print the file contents as a string.
 		//fmt.Println("File contents as a string:")
 		//fmt.Println(fileContentString)
-		torrentInfo := getTorrentInfo(fileContentString)
-		url, length, sha1Hash, pieceLength, pieces :=
-			torrentInfo.Announce, torrentInfo.TotalLength,
-			torrentInfo.InfoHash, torrentInfo.PieceLength,
-			torrentInfo.Pieces
-		fmt.Println("Tracker URL:", url)
-		fmt.Println("Length:", length)
-		fmt.Println("Info Hash:", sha1Hash)
-		fmt.Println("Piece Length:", pieceLength)
-		fmt.Println("Piece Hashes:")
-		for _, value := range pieces {
-			fmt.Println(value)
+		torrentInfo, err := getTorrentInfoWithOptions(fileContentString, TorrentOptions{Strict: *strict})
+		if err != nil {
+			log.Fatal(err)
 		}
+		if *jsonOutput {
+			if err := printTorrentInfoJSON(os.Stdout, torrentInfo); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		printTorrentInfo(torrentInfo)
 	} else if command == "peers" {
 		filePath := os.Args[2]
 		peers, err := getTrackerResponse(filePath)
@@ -196,7 +402,11 @@ func main() {
 
 		// Convert the byte slice to a string.
 		fileContentString := string(content)
-		infoHashRaw := getTorrentInfo(fileContentString).RawInfoHash
+		torrentInfo, err := getTorrentInfo(fileContentString)
+		if err != nil {
+			log.Fatal(err)
+		}
+		infoHashRaw := torrentInfo.RawInfoHash
 
 		var length uint8 = 19
 		var protocol []byte = []byte("BitTorrent protocol")
@@ -235,95 +445,29 @@ func main() {
 		pieceToDownload, _ := strconv.Atoi(os.Args[5])
 		filePath := os.Args[3]
 
-		content, err := os.ReadFile(torrentPath)
+		// The torrent is a .torrent file or a magnet link, whose metadata
+		// is fetched from peers first.
+		torrentInfo, announcer, peers, err := loadTorrent(torrentPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		// Convert the byte slice to a string.
-		fileContentString := string(content)
-		torrentInfo := getTorrentInfo(fileContentString)
-		peers, err := getTrackerResponse(torrentPath)
-		address := peers[0]
-
-		var length uint8 = 19
-		var protocol []byte = []byte("BitTorrent protocol")
-		reservedBytes := make([]byte, 8)
-		shaInfoHash := []byte(torrentInfo.RawInfoHash)
-		peerId := []byte("00112233445566778899")
-
-		var request []byte
-		request = append(request, length)
-		request = append(request, protocol...)
-		request = append(request, reservedBytes...)
-		request = append(request, shaInfoHash...)
-		request = append(request, peerId...)
-
-		conn, err := net.Dial("tcp", address)
-		defer conn.Close()
-		if err != nil {
-			log.Fatal("Failed to establish tcp connection with - ", address)
-		}
-
-		conn.Write(request)
-
-		// Receive a response from the server
-		buffer := make([]byte, 68)
-		_, err = conn.Read(buffer)
-
-		if err != nil {
-			fmt.Println("Error receiving buffer, err-", err)
-		}
-
-		if err != nil {
-			log.Fatalf("%v", err)
-		}
-
-		msg, err := waitForMessage(conn)
-		if msg.Id != bitfield || err != nil {
-			log.Fatalf("Expected bitfield message as first message", err)
+		// log.Fatal skips deferred calls, so every exit from here on tells
+		// the trackers we've stopped itself.
+		if pieceToDownload < 0 || pieceToDownload >= torrentInfo.pieceCount() {
+			announceStopped(announcer)
+			log.Fatalf("Piece %d out of range, the torrent has %d pieces", pieceToDownload, torrentInfo.pieceCount())
 		}
-
-		// send interested msg
-		msgToSent := PeerMessage{
-			PayloadLength: 1,
-			Id:            interested,
-			Payload:       nil,
+		sources := newPieceSources(torrentInfo, peers)
+		defer closePieceSources(sources)
+		if len(sources) == 0 {
+			announceStopped(announcer)
+			log.Fatal("No peers or web seeds to download from")
 		}
-		sendMessage(conn, msgToSent)
 
-		//buffer = make([]byte, 5)
-		//conn.Read(buffer)
-		//if err != nil {
-		//	log.Fatalf("Error occured for waiting message for unchoke %v", err)
-		//}
-		//wait for unchoke msg
-		msg, err = waitForMessage(conn)
-		if msg.Id != unchoke || err != nil {
-			log.Fatalf("Expected unchoke message as second message, err -", err)
-		}
-
-		numberOfPieces := len(torrentInfo.Pieces)
-
-		blockSize := 16384
-		pieceSize := blockSize
-		block := downloadPiece(torrentInfo, blockSize, msgToSent, pieceToDownload, numberOfPieces, pieceSize, conn)
-		//for i := 0; i < numberOfPieces; i++ {
-		//	//writer := bytes.NewBuffer([]byte{})
-		//	//err = bencode.Marshal(writer, block)
-		//	//if err != nil {
-		//	//	fmt.Println(err)
-		//	//}
-		//	//sha1Hash := sha1.New()
-		//	//sha1Hash.Write(writer.Bytes())
-		//	//blockHash := sha1Hash.Sum(nil)
-		//	//if fmt.Sprintf("%x", blockHash) != torrentInfo.Pieces[i] {
-		//	//	log.Fatalf("Hashes are not equal \n blockhash-%x \n pieceHash - %v", blockHash, torrentInfo.Pieces[i])
-		//	//}
-		//}
-		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-		defer file.Close()
+		block, err := fetchPieceFromAny(sources, torrentInfo, pieceToDownload)
+		announceStopped(announcer)
 		if err != nil {
-			log.Fatalf("Not able to create file from scratch, err-", err)
+			log.Fatal(err)
 		}
 		err = ioutil.WriteFile(filePath, block, 0644)
 		if err != nil {
@@ -334,103 +478,113 @@ func main() {
 		torrentPath := os.Args[4]
 		filePath := os.Args[3]
 
-		content, err := os.ReadFile(torrentPath)
+		// The torrent is a .torrent file or a magnet link, whose metadata
+		// is fetched from peers first.
+		torrentInfo, announcer, peers, err := loadTorrent(torrentPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		// Convert the byte slice to a string.
-		fileContentString := string(content)
-		torrentInfo := getTorrentInfo(fileContentString)
-		peers, err := getTrackerResponse(torrentPath)
-		address := peers[0]
-
-		var length uint8 = 19
-		var protocol []byte = []byte("BitTorrent protocol")
-		reservedBytes := make([]byte, 8)
-		shaInfoHash := []byte(torrentInfo.RawInfoHash)
-		peerId := []byte("00112233445566778899")
-
-		var request []byte
-		request = append(request, length)
-		request = append(request, protocol...)
-		request = append(request, reservedBytes...)
-		request = append(request, shaInfoHash...)
-		request = append(request, peerId...)
+		// log.Fatal skips deferred calls, so every exit from here on tells
+		// the trackers we've stopped itself.
+		sources := newPieceSources(torrentInfo, peers)
+		defer closePieceSources(sources)
+		if len(sources) == 0 {
+			announceStopped(announcer)
+			log.Fatal("No peers or web seeds to download from")
+		}
 
-		conn, err := net.Dial("tcp", address)
-		defer conn.Close()
+		// For a multi-file torrent filePath is the directory the files go in.
+		storage, err := newTorrentStorage(filePath, torrentInfo)
 		if err != nil {
-			log.Fatal("Failed to establish tcp connection with - ", address)
+			announceStopped(announcer)
+			log.Fatalf("Not able to create file from scratch, err- %v", err)
 		}
 
-		conn.Write(request)
-
-		// Receive a response from the server
-		buffer := make([]byte, 68)
-		_, err = conn.Read(buffer)
-
+		// Pieces are written straight to their offset in the output files so
+		// memory use doesn't grow with the size of the torrent.
+		downloaded, err := downloadPieces(torrentInfo, sources, storage, announcer)
 		if err != nil {
-			fmt.Println("Error receiving buffer, err-", err)
+			announceStopped(announcer)
+			log.Fatal(err)
 		}
-
+		if announcer != nil {
+			if _, err := announcer.Announce(EventCompleted); err != nil {
+				log.Printf("Unable to announce completion: %v", err)
+			}
+		}
+		announceStopped(announcer)
+		if downloaded != torrentInfo.wantedLength() {
+			log.Fatalf("Size of downloaded content not same as torrent total length. Downloaded size: %v torrent total length: %v", downloaded, torrentInfo.wantedLength())
+		}
+		if err := applyFileAttributes(filePath, torrentInfo); err != nil {
+			log.Fatalf("Not able to apply file attributes, err- %v", err)
+		}
+		fmt.Printf("Downloaded %v to %v.\n", torrentPath, filePath)
+	} else if command == "magnet_parse" {
+		magnet, err := parseMagnet(os.Args[2])
 		if err != nil {
-			log.Fatalf("%v", err)
+			log.Fatal(err)
 		}
-
-		msg, err := waitForMessage(conn)
-		if msg.Id != bitfield || err != nil {
-			log.Fatalf("Expected bitfield message as first message", err)
+		if len(magnet.Trackers) > 0 {
+			fmt.Println("Tracker URL:", magnet.Trackers[0])
 		}
-
-		// send interested msg
-		msgToSent := PeerMessage{
-			PayloadLength: 1,
-			Id:            interested,
-			Payload:       nil,
+		if magnet.InfoHash != nil {
+			fmt.Println("Info Hash:", hex.EncodeToString(magnet.InfoHash))
 		}
-		sendMessage(conn, msgToSent)
-
-		//wait for unchoke msg
-		msg, err = waitForMessage(conn)
-		if msg.Id != unchoke || err != nil {
-			log.Fatalf("Expected unchoke message as second message, err -", err)
+		if magnet.InfoHashV2 != nil {
+			fmt.Println("Info Hash v2:", hex.EncodeToString(magnet.InfoHashV2))
 		}
+	} else if command == "magnet_info" {
+		flags := flag.NewFlagSet("magnet_info", flag.ExitOnError)
+		output := flags.String("o", "", "also save the metadata as a .torrent file")
+		flags.Parse(os.Args[2:])
 
-		numberOfPieces := len(torrentInfo.Pieces)
-
-		blockSize := 16384
-		pieceSize := blockSize
-		everyBlock := make([]byte, 0)
-		for i := 0; i < numberOfPieces; i++ {
-			block := downloadPiece(torrentInfo, blockSize, msgToSent, i, numberOfPieces, pieceSize, conn)
-			everyBlock = append(everyBlock, block...)
-		}
-		//for i := 0; i < numberOfPieces; i++ {
-		//	//writer := bytes.NewBuffer([]byte{})
-		//	//err = bencode.Marshal(writer, block)
-		//	//if err != nil {
-		//	//	fmt.Println(err)
-		//	//}
-		//	//sha1Hash := sha1.New()
-		//	//sha1Hash.Write(writer.Bytes())
-		//	//blockHash := sha1Hash.Sum(nil)
-		//	//if fmt.Sprintf("%x", blockHash) != torrentInfo.Pieces[i] {
-		//	//	log.Fatalf("Hashes are not equal \n blockhash-%x \n pieceHash - %v", blockHash, torrentInfo.Pieces[i])
-		//	//}
-		//}
-		if len(everyBlock) != torrentInfo.TotalLength {
-			log.Fatalf("Size of downloaded content not same as torrent total length. Downloaded size:", len(everyBlock), "torrent total length:", torrentInfo.TotalLength)
-		}
-		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-		defer file.Close()
+		magnet, err := parseMagnet(flags.Arg(0))
 		if err != nil {
-			log.Fatalf("Not able to create file from scratch, err-", err)
+			log.Fatal(err)
 		}
-		err = ioutil.WriteFile(filePath, everyBlock, 0644)
+		var trackerPeers []string
+		if len(magnet.Trackers) > 0 {
+			peers, err := getPeers(torrentInfoFromMagnet(magnet))
+			if err != nil {
+				log.Fatal(err)
+			}
+			trackerPeers = peerStrings(peers)
+		}
+		metadata, err := metadataFromMagnet(magnet, trackerPeers)
 		if err != nil {
-			log.Fatalf("Data not written")
+			log.Fatal(err)
+		}
+		torrentInfo, err := torrentInfoFromMetadata(metadata)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *output != "" {
+			content, err := bencode.Marshal(metadata)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := os.WriteFile(*output, content, 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+		printTorrentInfo(torrentInfo)
+	} else if command == "create" {
+		if err := runCreate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "validate" {
+		if err := runValidate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "edit" {
+		if err := runEdit(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if command == "scrape" {
+		if err := runScrape(os.Args[2:]); err != nil {
+			log.Fatal(err)
 		}
-		fmt.Printf("Downloaded %v to %v.\n", torrentPath, filePath)
 	} else {
 		fmt.Println("Unknown command: " + command)
 		os.Exit(1)
@@ -438,56 +592,99 @@ func main() {
 
 }
 
-func downloadPiece(torrentInfo TorrentInfo, blockSize int, msgToSent PeerMessage, pieceIndex int, numberOfPieces int, pieceSize int, conn net.Conn) []byte {
-	block := []byte{}
-	pieceLength := torrentInfo.PieceLength
-	if pieceIndex == numberOfPieces-1 {
-		pieceLength = torrentInfo.TotalLength - (pieceLength * (numberOfPieces - 1))
-	}
-	numberOfBlock := int(math.Ceil(float64(pieceLength) / (float64(blockSize))))
-	//fmt.Println("Total number of blocks", numberOfBlock)
-	for j := 0; j < numberOfBlock; j++ {
-		msgToSent = PeerMessage{
-			PayloadLength: 0,
-			Id:            MessageId(6),
-			Payload:       nil,
-		}
-		payload := intToBytes(pieceIndex)
-		payload = append(payload, intToBytes(blockSize*j)...)
-		if pieceIndex == numberOfPieces-1 && j == (numberOfBlock-1) {
-			pieceSize = pieceLength % blockSize
-		}
-		payload = append(payload, intToBytes(pieceSize)...)
-		msgToSent.PayloadLength = 13
-		msgToSent.Payload = payload
-		//fmt.Printf("Piece size for %v is %v\n", j, pieceSize)
-		err := sendMessage(conn, msgToSent)
+// printTorrentInfo prints the summary the info command shows.
+func printTorrentInfo(torrentInfo TorrentInfo) {
+	url, length, sha1Hash, pieceLength, pieces :=
+		torrentInfo.Announce, torrentInfo.TotalLength,
+		torrentInfo.InfoHash, torrentInfo.PieceLength,
+		torrentInfo.Pieces
+	fmt.Println("Tracker URL:", url)
+	fmt.Println("Length:", length)
+	fmt.Println("Info Hash:", sha1Hash)
+	if torrentInfo.InfoHashV2 != "" {
+		fmt.Println("Info Hash v2:", torrentInfo.InfoHashV2)
+	}
+	fmt.Println("Piece Length:", pieceLength)
+	if torrentInfo.Private {
+		fmt.Println("Private: yes")
+	}
+	fmt.Println("Piece Hashes:")
+	for _, value := range pieces {
+		fmt.Println(value)
+	}
+	if torrentInfo.MultiFile {
+		fmt.Println("Files:")
+		for _, file := range torrentInfo.Files {
+			if file.Padding {
+				continue
+			}
+			fmt.Printf("%d %s\n", file.Length, strings.Join(file.Path, "/"))
+		}
+	}
+}
+
+// downloadPiece requests piece pieceIndex from the peer on conn one block
+// at a time and checks it against its hash.
+func downloadPiece(torrentInfo TorrentInfo, blockSize int64, pieceIndex int, conn net.Conn) ([]byte, error) {
+	block, err := requestPiece(torrentInfo, blockSize, pieceIndex, conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := torrentInfo.verifyPiece(pieceIndex, block); err != nil {
+		return nil, fmt.Errorf("piece hashes doesnt match: %w", err)
+	}
+	return block, nil
+}
 
+// requestPiece requests piece pieceIndex from the peer on conn one block
+// at a time, without checking it.
+func requestPiece(torrentInfo TorrentInfo, blockSize int64, pieceIndex int, conn net.Conn) ([]byte, error) {
+	block := []byte{}
+	pieceLength := torrentInfo.pieceLength(pieceIndex)
+	numberOfBlock := (pieceLength + blockSize - 1) / blockSize
+	for j := int64(0); j < numberOfBlock; j++ {
+		begin := blockSize * j
+		pieceSize := blockSize
+		if begin+pieceSize > pieceLength {
+			pieceSize = pieceLength - begin
+		}
+		payload, err := blockRequestPayload(int64(pieceIndex), begin, pieceSize)
 		if err != nil {
-			log.Fatalf("Error sending block request : %v", msgToSent)
+			return nil, fmt.Errorf("invalid block request: %w", err)
+		}
+		msgToSent := PeerMessage{
+			PayloadLength: 13,
+			Id:            request,
+			Payload:       payload,
+		}
+		if err := sendMessage(conn, msgToSent); err != nil {
+			return nil, fmt.Errorf("error sending block request: %w", err)
 		}
 
 		msg, err := waitForMessage(conn)
-		if msg.Id != piece || err != nil {
-			fmt.Printf("Expected PIECE msg for the msgSent - %+v, received msg %+v, torrentInfo %+v\n", msgToSent, msg, torrentInfo)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Id != piece || len(msg.Payload) < 8 ||
+			!bytes.Equal(msg.Payload[:8], payload[:8]) || int64(len(msg.Payload)-8) != pieceSize {
+			return nil, fmt.Errorf("expected PIECE msg for the msgSent - %+v, received msg id %d", msgToSent, msg.Id)
 		}
 		block = append(block, msg.Payload[8:]...)
 	}
-	sha1Hash := sha1.New()
-	sha1Hash.Write(block)
-	hashBytes := sha1Hash.Sum(nil)
-	blockSha1HexHash := fmt.Sprintf("%x", hashBytes)
-	if len(block) != pieceLength || blockSha1HexHash != torrentInfo.Pieces[pieceIndex] {
-		log.Fatalf("Piece hashes doesnt match")
-	}
-	return block
+	return block, nil
 }
 
+// maxPeerMessageLength bounds the messages we accept from a peer. The
+// largest legitimate one is the bitfield of a torrent with millions of
+// pieces.
+const maxPeerMessageLength = 16 << 20
+
 func waitForMessage(conn net.Conn) (*PeerMessage, error) {
 
 	// TODO make 30 a constant
 	timer := time.NewTimer(30 * time.Second)
-	msgChan := make(chan *PeerMessage)
+	msgChan := make(chan *PeerMessage, 1)
+	errorChan := make(chan error, 1)
 
 	go func() {
 		peerMessage := PeerMessage{
@@ -496,30 +693,26 @@ func waitForMessage(conn net.Conn) (*PeerMessage, error) {
 			Payload:       make([]uint8, 0),
 		}
 
-		//message length
-		//var messageLength int32
-		//TODO put error handling
-		bufioReader := bufio.NewReader(conn)
-
-		buffer := make([]byte, 4)
-		n, err := io.ReadFull(bufioReader, buffer)
-		if n != 4 || err != nil {
-			fmt.Println("not enough data to read? number of bytes:%v, err- %v", n, err)
-		}
-		err = binary.Read(bytes.NewReader(buffer), binary.BigEndian, &peerMessage.PayloadLength)
-		if err != nil {
-			fmt.Println("cant read length: ", err)
+		// Read straight from conn: a buffered reader here would swallow the
+		// start of any message the peer sent right behind this one.
+		for peerMessage.PayloadLength <= 0 {
+			// A zero length is a keep-alive, which carries no message.
+			if err := binary.Read(conn, binary.BigEndian, &peerMessage.PayloadLength); err != nil {
+				errorChan <- fmt.Errorf("cant read length: %w", err)
+				return
+			}
+			if peerMessage.PayloadLength < 0 || peerMessage.PayloadLength > maxPeerMessageLength {
+				errorChan <- fmt.Errorf("invalid message length %d", peerMessage.PayloadLength)
+				return
+			}
 		}
-		buffer = make([]byte, 1)
-		n, err = io.ReadFull(bufioReader, buffer)
-		binary.Read(bytes.NewReader(buffer), binary.BigEndian, &peerMessage.Id)
-
-		if peerMessage.PayloadLength > 1 {
-			buffer = make([]byte, peerMessage.PayloadLength-1)
-			n, err = io.ReadFull(bufioReader, buffer)
-			peerMessage.Payload = make([]uint8, peerMessage.PayloadLength-1)
-			binary.Read(bytes.NewReader(buffer), binary.BigEndian, &peerMessage.Payload)
+		buffer := make([]byte, peerMessage.PayloadLength)
+		if _, err := io.ReadFull(conn, buffer); err != nil {
+			errorChan <- fmt.Errorf("cant read message: %w", err)
+			return
 		}
+		peerMessage.Id = MessageId(buffer[0])
+		peerMessage.Payload = buffer[1:]
 
 		msgChan <- &peerMessage
 	}()
@@ -527,13 +720,11 @@ func waitForMessage(conn net.Conn) (*PeerMessage, error) {
 	select {
 	case <-timer.C:
 		return nil, fmt.Errorf("timed Out, no msg received")
+	case err := <-errorChan:
+		return nil, err
 	case msg := <-msgChan:
 		return msg, nil
 	}
-
-	// now return the message
-
-	return nil, fmt.Errorf("Something went horrible")
 }
 
 func sendMessage(conn net.Conn, message PeerMessage) error {
@@ -571,6 +762,13 @@ func sendMessage(conn net.Conn, message PeerMessage) error {
 	}
 }
 
+// TorrentOptions controls how getTorrentInfoWithOptions reads a torrent.
+type TorrentOptions struct {
+	// Strict rejects metainfo that is not canonical BEP 3 bencoding, or
+	// that has data after the top-level dictionary.
+	Strict bool
+}
+
 // getTorrentInfo extracts relevant information from a Bencode-encoded torrent metadata string.
 // It returns the following information:
 //   - Announce URL: the URL where the torrent tracker is located.
@@ -579,46 +777,90 @@ func sendMessage(conn net.Conn, message PeerMessage) error {
 //   - Piece Length: the size of each piece in bytes.
 //   - Pieces: a slice of piece hashes.
 //   - Hash Bytes: the raw hash bytes of the torrent info.
-func getTorrentInfo(contentString string) TorrentInfo {
-	decodedData, err := decodeBencoded(contentString)
-	if err != nil {
-		fmt.Printf("Invalid decoding string to fetch info %v", err)
-	}
+//
+// The info hash is computed over the info dictionary exactly as it appears
+// in the file, so keys the Metadata struct doesn't model still count.
+//
+// v2 and hybrid torrents (BEP 52) also get the SHA-256 info hash, and
+// their piece layers are checked against each file's pieces root.
+func getTorrentInfo(contentString string) (TorrentInfo, error) {
+	return getTorrentInfoWithOptions(contentString, TorrentOptions{})
+}
 
-	// process of marshalling a bencoding to a struct
-	marshalledBytes := bytes.NewBuffer([]byte{})
-	err = bencode.Marshal(marshalledBytes, decodedData)
-	if err != nil {
-		fmt.Println(err)
+// getTorrentInfoWithOptions is getTorrentInfo with control over decoding.
+func getTorrentInfoWithOptions(contentString string, options TorrentOptions) (TorrentInfo, error) {
+	decoder := bencode.NewDecoder(strings.NewReader(contentString))
+	if options.Strict {
+		decoder.UseStrict()
 	}
 	metadata := Metadata{}
-	bencode.Unmarshal(marshalledBytes, &metadata)
-	//fmt.Println("metadata info: ", metadata.Info)
+	err := decoder.DecodeInto(&metadata)
+	if err == nil && options.Strict {
+		err = decoder.ExpectEnd()
+	}
 	if err != nil {
-		fmt.Println(err)
+		return TorrentInfo{}, fmt.Errorf("invalid torrent metadata: %w", err)
+	}
+	if len(metadata.Info) == 0 {
+		return TorrentInfo{}, fmt.Errorf("invalid torrent metadata: missing info dictionary")
+	}
+	return torrentInfoFromMetadata(metadata)
+}
+
+// torrentInfoFromMetadata builds the TorrentInfo for decoded metainfo.
+func torrentInfoFromMetadata(metadata Metadata) (TorrentInfo, error) {
+	info := MetadataInfo{}
+	if err := bencode.Unmarshal(metadata.Info, &info); err != nil {
+		return TorrentInfo{}, fmt.Errorf("invalid torrent info dictionary: %w", err)
 	}
 
-	writer := bytes.NewBuffer([]byte{})
-	err = bencode.Marshal(writer, metadata.Info)
+	if info.MetaVersion > 2 {
+		return TorrentInfo{}, fmt.Errorf("unsupported meta version %d", info.MetaVersion)
+	}
+	webSeeds, err := getWebSeeds(metadata.URLList)
 	if err != nil {
-		fmt.Println(err)
+		return TorrentInfo{}, fmt.Errorf("invalid torrent metadata: %w", err)
 	}
-	sha1Hash := sha1.New()
-	sha1Hash.Write(writer.Bytes())
-	hashBytes := sha1Hash.Sum(nil)
-	hashString := fmt.Sprintf("%x", hashBytes)
 
-	pieces, err := getPieces(metadata.Info.Pieces)
 	torrentInfo := TorrentInfo{
-		Announce:    metadata.Announce,
-		TotalLength: metadata.Info.Length,
-		InfoHash:    hashString,
-		PieceLength: metadata.Info.PieceLength,
-		Pieces:      pieces,
-		RawInfoHash: hashBytes,
+		Announce:     metadata.Announce,
+		AnnounceList: metadata.AnnounceList,
+		Name:         info.Name,
+		PieceLength:  info.PieceLength,
+		RawInfo:      metadata.Info,
+		WebSeeds:     webSeeds,
+		Private:      info.Private,
+		Comment:      rawStringOrEmpty(metadata.Comment),
+		CreatedBy:    rawStringOrEmpty(metadata.CreatedBy),
+		CreationDate: rawIntOrZero(metadata.CreationDate),
+		Encoding:     rawStringOrEmpty(metadata.Encoding),
+		Nodes:        getNodes(metadata.Nodes),
+		MetaVersion:  1,
+	}
+	// A v2 torrent is hybrid when it also has v1 pieces.
+	if info.MetaVersion < 2 || info.Pieces != "" {
+		hashBytes := sha1.Sum(metadata.Info)
+		files, totalLength, err := getFiles(info)
+		if err != nil {
+			return TorrentInfo{}, fmt.Errorf("invalid torrent info dictionary: %w", err)
+		}
+		pieces, err := getPieces(info.Pieces)
+		if err != nil {
+			return TorrentInfo{}, fmt.Errorf("invalid torrent info dictionary: %w", err)
+		}
+		torrentInfo.TotalLength = totalLength
+		torrentInfo.InfoHash = fmt.Sprintf("%x", hashBytes)
+		torrentInfo.Pieces = pieces
+		torrentInfo.RawInfoHash = hashBytes[:]
+		torrentInfo.Files = files
+		torrentInfo.MultiFile = len(info.Files) > 0
+	}
+	if info.MetaVersion == 2 {
+		if err := torrentInfo.addV2(info, metadata.Info, metadata.PieceLayers); err != nil {
+			return TorrentInfo{}, fmt.Errorf("invalid torrent info dictionary: %w", err)
+		}
 	}
-	//return metadata.Announce, metadata.Info.Length, hashString, metadata.Info.PieceLength, pieces, hashBytes
-	return torrentInfo
+	return torrentInfo, nil
 }
 
 func getPieces(pieces string) ([]string, error) {
@@ -636,81 +878,81 @@ func getPieces(pieces string) ([]string, error) {
 	return piecesList, nil
 }
 
-func getTrackerResponse(filePath string) ([]string, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	fileContentString := string(content)
-	torrentInfo := getTorrentInfo(fileContentString)
-
-	baseUrl, length, infoHashRaw := torrentInfo.Announce, torrentInfo.TotalLength, torrentInfo.RawInfoHash
-	params := url.Values{}
-	params.Add("info_hash", string(infoHashRaw))
-	params.Add("peer_id", "00112233445566778899")
-	params.Add("port", "6881")
-	params.Add("uploaded", strconv.Itoa(0))
-	params.Add("downloaded", strconv.Itoa(0))
-	params.Add("left", strconv.Itoa(length))
-	params.Add("compact", strconv.Itoa(1))
-
-	requestUrl := baseUrl + "?" + params.Encode()
-	resp, err := http.Get(requestUrl)
-	defer resp.Body.Close()
-	if err != nil {
-		return nil, err
-	}
+type Metadata struct {
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	// Comment, CreatedBy, CreationDate and Encoding are only displayed, so
+	// they are kept undecoded and read with rawString and rawInt: a
+	// torrent with one of them mistyped still loads.
+	Comment      bencode.RawMessage `bencode:"comment,omitempty"`
+	CreatedBy    bencode.RawMessage `bencode:"created by,omitempty"`
+	CreationDate bencode.RawMessage `bencode:"creation date,omitempty"`
+	Encoding     bencode.RawMessage `bencode:"encoding,omitempty"`
+	Info         bencode.RawMessage `bencode:"info"`
+	// Nodes are DHT bootstrap nodes (BEP 5), [host, port] pairs, read
+	// leniently by getNodes.
+	Nodes bencode.RawMessage `bencode:"nodes,omitempty"`
+	// PieceLayers maps the pieces root of each v2 file larger than a piece
+	// to the concatenated hashes of its pieces (BEP 52).
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+	// URLList is a single URL or a list of them (BEP 19), so it is kept
+	// undecoded here.
+	URLList bencode.RawMessage `bencode:"url-list,omitempty"`
+}
 
-	body, err := io.ReadAll(resp.Body)
-	respBenDict := string(body)
-	decodedValue, err := decodeBencoded(respBenDict)
-	if err != nil {
-		return nil, err
+// rawString decodes a byte string kept as raw, reporting false if raw holds
+// some other type. An absent value is the empty string.
+func rawString(raw bencode.RawMessage) (string, bool) {
+	var s string
+	if len(raw) == 0 {
+		return "", true
 	}
-
-	peers := getPeersList(decodedValue)
-	return peers, nil
-
+	if err := bencode.Unmarshal(raw, &s); err != nil {
+		return "", false
+	}
+	return s, true
 }
 
-func getPeersList(decodedValue interface{}) []string {
-	marshalledBytes := bytes.NewBuffer([]byte{})
-	err := bencode.Marshal(marshalledBytes, decodedValue)
-	if err != nil {
-		fmt.Println(err)
+// rawInt is like rawString for integers.
+func rawInt(raw bencode.RawMessage) (int64, bool) {
+	var n int64
+	if len(raw) == 0 {
+		return 0, true
 	}
-	trackerResponse := TrackerResponse{}
-	bencode.Unmarshal(marshalledBytes, &trackerResponse)
-
-	var peersList []string
-	// convert to byte array
-
-	byteArr := []byte(trackerResponse.Peers)
-
-	i := 0
-	for i+6 <= len(byteArr) {
-		address := fmt.Sprintf("%v.%v.%v.%v", byteArr[i], byteArr[i+1], byteArr[i+2], byteArr[i+3])
-		port := binary.BigEndian.Uint16(byteArr[i+4 : i+6])
-		peersList = append(peersList, fmt.Sprintf("%s:%d", address, port))
-		i += 6
+	if err := bencode.Unmarshal(raw, &n); err != nil {
+		return 0, false
 	}
-	return peersList
+	return n, true
 }
 
-type Metadata struct {
-	Announce string       `bencode:"announce"`
-	Info     MetadataInfo `bencode:"info"`
+func rawStringOrEmpty(raw bencode.RawMessage) string {
+	s, _ := rawString(raw)
+	return s
 }
+
+func rawIntOrZero(raw bencode.RawMessage) int64 {
+	n, _ := rawInt(raw)
+	return n
+}
+
 type MetadataInfo struct {
-	Length      int    `bencode:"length"`
+	Length      int64  `bencode:"length,omitempty"`
 	Name        string `bencode:"name"`
-	PieceLength int    `bencode:"piece length"`
+	PieceLength int64  `bencode:"piece length"`
 	Pieces      string `bencode:"pieces"`
-}
-
-type TrackerResponse struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	Private     bool   `bencode:"private,omitempty"`
+	Source      string `bencode:"source,omitempty"`
+	// Attr and SymlinkPath are the BEP 47 attributes of a single file.
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+
+	// Files is set instead of Length for multi-file torrents.
+	Files []MetadataFile `bencode:"files,omitempty"`
+
+	// MetaVersion is 2 for v2 and hybrid torrents (BEP 52), which describe
+	// their files in FileTree. Hybrid torrents also carry the v1 keys.
+	MetaVersion int64                  `bencode:"meta version,omitempty"`
+	FileTree    map[string]interface{} `bencode:"file tree,omitempty"`
 }
 
 type PeerMessage struct {
@@ -748,16 +990,126 @@ const (
 //}
 
 type TorrentInfo struct {
-	Announce    string
-	TotalLength int
-	InfoHash    string
-	PieceLength int
-	Pieces      []string
-	RawInfoHash []byte
+	Announce     string
+	AnnounceList [][]string
+	Name         string
+	TotalLength  int64
+	InfoHash     string
+	PieceLength  int64
+	Pieces       []string
+	RawInfoHash  []byte
+	RawInfo      bencode.RawMessage
+	Files        []TorrentFile
+	MultiFile    bool
+	// WebSeeds are the HTTP servers from url-list (BEP 19).
+	WebSeeds []string
+	// Private is the BEP 27 private flag: peers come only from the
+	// torrent's trackers.
+	Private bool
+
+	Comment      string
+	CreatedBy    string
+	CreationDate int64
+	Encoding     string
+	// Nodes are the DHT bootstrap nodes as host:port.
+	Nodes []string
+
+	// MetaVersion is 2 for v2 and hybrid torrents. For a v2-only torrent
+	// RawInfoHash is the v2 info hash truncated to 20 bytes and Pieces is
+	// empty; pieces are verified against PieceLayers, keyed by pieces root.
+	MetaVersion   int64
+	InfoHashV2    string
+	RawInfoHashV2 []byte
+	PieceLayers   map[string][]byte
+}
+
+// pieceOffset returns the offset of piece index within the torrent content.
+func (t TorrentInfo) pieceOffset(index int) int64 {
+	return int64(index) * t.PieceLength
 }
 
-func intToBytes(num int) []uint8 {
+// pieceLength returns the size of piece index; only the last piece can be
+// shorter than PieceLength, or in a v2-only torrent the last piece of each
+// file.
+func (t TorrentInfo) pieceLength(index int) int64 {
+	if t.isV2Only() {
+		f, _, _ := t.pieceFile(index)
+		if end := f.Offset + f.Length; end-t.pieceOffset(index) < t.PieceLength {
+			return end - t.pieceOffset(index)
+		}
+		return t.PieceLength
+	}
+	if index == len(t.Pieces)-1 {
+		return t.TotalLength - t.pieceOffset(index)
+	}
+	return t.PieceLength
+}
+
+func (t TorrentInfo) isV2Only() bool {
+	return t.MetaVersion == 2 && len(t.Pieces) == 0
+}
+
+// pieceCount returns the number of pieces in the torrent. v2 files each
+// start a new piece.
+func (t TorrentInfo) pieceCount() int {
+	if !t.isV2Only() {
+		return len(t.Pieces)
+	}
+	count := 0
+	for _, f := range t.Files {
+		count += int((f.Length + t.PieceLength - 1) / t.PieceLength)
+	}
+	return count
+}
+
+// verifyPiece checks the content of piece index against its hash.
+func (t TorrentInfo) verifyPiece(index int, data []byte) error {
+	if int64(len(data)) != t.pieceLength(index) {
+		return fmt.Errorf("piece %d is %d bytes, expected %d", index, len(data), t.pieceLength(index))
+	}
+	if t.isV2Only() {
+		return t.verifyPieceV2(index, data)
+	}
+	if fmt.Sprintf("%x", sha1.Sum(data)) != t.Pieces[index] {
+		return fmt.Errorf("piece %d hash does not match", index)
+	}
+	return nil
+}
+
+// blockRequestPayload builds the payload of a request message. The peer
+// protocol carries each field as a uint32, so values that don't fit are
+// rejected rather than silently truncated.
+func blockRequestPayload(pieceIndex, begin, length int64) ([]uint8, error) {
+	payload := make([]uint8, 0, 12)
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{{"piece index", pieceIndex}, {"begin", begin}, {"length", length}} {
+		bytes, err := uint32ToBytes(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		payload = append(payload, bytes...)
+	}
+	return payload, nil
+}
+
+func uint32ToBytes(num int64) ([]uint8, error) {
+	if num < 0 || num > math.MaxUint32 {
+		return nil, fmt.Errorf("%d out of uint32 range", num)
+	}
 	bytes := make([]uint8, 4)
 	binary.BigEndian.PutUint32(bytes, uint32(num))
-	return bytes
+	return bytes, nil
+}
+
+// announceStopped tells the trackers, if there are any, that we are done
+// with the torrent.
+func announceStopped(announcer *Announcer) {
+	if announcer == nil {
+		return
+	}
+	if _, err := announcer.Announce(EventStopped); err != nil {
+		log.Printf("Unable to announce stop: %v", err)
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"strings"
+	"testing"
+)
+
+func TestGetTorrentInfoStrict(t *testing.T) {
+	pieces := strings.Repeat("p", 20)
+	info := "d6:lengthi5e4:name1:x12:piece lengthi16384e6:pieces20:" + pieces + "e"
+	canonical := "d8:announce3:url4:info" + info + "e"
+	if _, err := getTorrentInfoWithOptions(canonical, TorrentOptions{Strict: true}); err != nil {
+		t.Fatalf("canonical torrent: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		rule    bencode.Rule
+	}{
+		{"unsorted keys", "d4:info" + info + "8:announce3:urle", bencode.RuleKeyOrder},
+		{"unsorted info keys",
+			"d8:announce3:url4:infod4:name1:x6:lengthi5e12:piece lengthi16384e6:pieces20:" + pieces + "ee",
+			bencode.RuleKeyOrder},
+		{"leading zero", "d8:announce3:url4:infod6:lengthi05e4:name1:x12:piece lengthi16384e6:pieces20:" + pieces + "ee",
+			bencode.RuleIntLeadingZero},
+		{"trailing data", canonical + "junk", bencode.RuleTrailingData},
+	}
+	for _, tt := range tests {
+		if _, err := getTorrentInfoWithOptions(tt.content, TorrentOptions{}); err != nil {
+			t.Errorf("%s: rejected outside strict mode: %v", tt.name, err)
+		}
+		_, err := getTorrentInfoWithOptions(tt.content, TorrentOptions{Strict: true})
+		var conformanceErr *bencode.ConformanceError
+		if !errors.As(err, &conformanceErr) || conformanceErr.Rule != tt.rule {
+			t.Errorf("%s: strict error %v, want %q", tt.name, err, tt.rule)
+		}
+	}
+}
+
+func TestSummarizeBytes(t *testing.T) {
+	hashes := strings.Repeat("\x00\xff", 20)
+	tests := []struct {
+		key  string
+		s    string
+		want string
+	}{
+		{"name", "file.txt", `"file.txt"`},
+		{"comment", strings.Repeat("a", 70), `"` + strings.Repeat("a", 60) + `"...`},
+		{"pieces", hashes, "binary, 2 SHA-1 hashes"},
+		// Binary strings under other keys are not hashes, however long.
+		{"peers", hashes, "binary, starts 00ff00ff00ff00ff00ff00ff00ff00ff..."},
+		{"[0]", hashes[:20], "binary, starts 00ff00ff00ff00ff00ff00ff00ff00ff..."},
+		{"pieces", "\x00\x01", "binary, 0001"},
+		{"pieces", hashes[:21], "binary, starts 00ff00ff00ff00ff00ff00ff00ff00ff..."},
+	}
+	for _, tt := range tests {
+		if got := summarizeBytes(tt.key, []byte(tt.s)); got != tt.want {
+			t.Errorf("summarizeBytes(%q, %q) = %q, want %q", tt.key, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Extension protocol (BEP 10) and metadata exchange (BEP 9).
+const (
+	// extended is the message id shared by every extension message. The
+	// first payload byte then picks the extension.
+	extended MessageId = 20
+	// extendedHandshakeId is the extension id of the extension handshake.
+	extendedHandshakeId = 0
+	// localMetadataId is the id we ask peers to use for ut_metadata
+	// messages they send us.
+	localMetadataId = 1
+
+	metadataPieceSize = 16 << 10
+	// maxMetadataSize caps the metadata_size a peer may announce. Info
+	// dictionaries are rarely more than a few MiB even for huge torrents.
+	maxMetadataSize = 64 << 20
+)
+
+// ut_metadata message types.
+const (
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+// peerTimeout bounds connecting to a peer and reading its handshake.
+const peerTimeout = 30 * time.Second
+
+// peerMessageLimits bounds the bencoded part of extension messages.
+var peerMessageLimits = bencode.Limits{
+	MaxDepth:     16,
+	MaxStringLen: 1 << 20,
+	MaxEntries:   10000,
+	MaxBytes:     maxPeerMessageLength,
+}
+
+// extensionHandshake is the payload of the BEP 10 extension handshake.
+type extensionHandshake struct {
+	// M maps extension names to the ids the sender wants to receive them
+	// under. An id of 0 means the extension is disabled.
+	M            map[string]int64 `bencode:"m"`
+	MetadataSize int64            `bencode:"metadata_size,omitempty"`
+	V            string           `bencode:"v,omitempty"`
+}
+
+// metadataMessage is the dictionary at the start of a ut_metadata message.
+// A data message carries the piece itself right after it.
+type metadataMessage struct {
+	MsgType   int64 `bencode:"msg_type"`
+	Piece     int64 `bencode:"piece"`
+	TotalSize int64 `bencode:"total_size,omitempty"`
+}
+
+// peerHandshake exchanges BitTorrent handshakes over conn and returns the
+// reserved bytes the peer sent. With extensions set we advertise support
+// for the extension protocol, and with v2 for BitTorrent v2 (BEP 52), which
+// peers want before they answer hash requests.
+func peerHandshake(conn net.Conn, infoHash []byte, extensions, v2 bool) ([]byte, error) {
+	reservedBytes := make([]byte, 8)
+	if extensions {
+		reservedBytes[5] |= 0x10
+	}
+	if v2 {
+		reservedBytes[7] |= 0x10
+	}
+	var request []byte
+	request = append(request, 19)
+	request = append(request, []byte("BitTorrent protocol")...)
+	request = append(request, reservedBytes...)
+	request = append(request, infoHash...)
+	request = append(request, []byte("00112233445566778899")...)
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(peerTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buffer := make([]byte, 68)
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return nil, fmt.Errorf("cant read handshake: %w", err)
+	}
+	if buffer[0] != 19 || string(buffer[1:20]) != "BitTorrent protocol" {
+		return nil, fmt.Errorf("peer did not answer with a BitTorrent handshake")
+	}
+	if !bytes.Equal(buffer[28:48], infoHash) {
+		return nil, fmt.Errorf("peer answered with info hash %x", buffer[28:48])
+	}
+	return buffer[20:28], nil
+}
+
+// sendExtended sends the extension message id with a bencoded payload.
+func sendExtended(conn net.Conn, id byte, payload interface{}) error {
+	encoded, err := bencode.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	message := append([]byte{id}, encoded...)
+	return sendMessage(conn, PeerMessage{
+		PayloadLength: int32(1 + len(message)),
+		Id:            extended,
+		Payload:       message,
+	})
+}
+
+// waitForExtended waits for an extension message with the given id,
+// skipping everything else the peer sends meanwhile (bitfield, have,
+// unchoke and so on).
+func waitForExtended(conn net.Conn, id byte) ([]byte, error) {
+	for {
+		msg, err := waitForMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Id == extended && len(msg.Payload) > 0 && msg.Payload[0] == id {
+			return msg.Payload[1:], nil
+		}
+	}
+}
+
+// fetchMetadata downloads the info dictionary of the torrent a magnet link
+// names from the peer at address, one 16 KiB piece at a time.
+func fetchMetadata(address string, magnet Magnet) (bencode.RawMessage, error) {
+	infoHash := magnetSwarmHash(magnet)
+	conn, err := net.DialTimeout("tcp", address, peerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	reserved, err := peerHandshake(conn, infoHash, true, false)
+	if err != nil {
+		return nil, err
+	}
+	if reserved[5]&0x10 == 0 {
+		return nil, fmt.Errorf("peer does not support the extension protocol")
+	}
+	handshake := extensionHandshake{M: map[string]int64{"ut_metadata": localMetadataId}}
+	if err := sendExtended(conn, extendedHandshakeId, handshake); err != nil {
+		return nil, err
+	}
+
+	payload, err := waitForExtended(conn, extendedHandshakeId)
+	if err != nil {
+		return nil, err
+	}
+	handshake = extensionHandshake{}
+	decoder := bencode.NewDecoder(bytes.NewReader(payload))
+	decoder.SetLimits(peerMessageLimits)
+	if err := decoder.DecodeInto(&handshake); err != nil {
+		return nil, fmt.Errorf("invalid extension handshake: %w", err)
+	}
+	peerMetadataId := handshake.M["ut_metadata"]
+	if peerMetadataId <= 0 || peerMetadataId > 255 {
+		return nil, fmt.Errorf("peer does not support ut_metadata")
+	}
+	size := handshake.MetadataSize
+	if size <= 0 || size > maxMetadataSize {
+		return nil, fmt.Errorf("peer announced metadata size %d", size)
+	}
+
+	metadata := make([]byte, 0, size)
+	numberOfPieces := (size + metadataPieceSize - 1) / metadataPieceSize
+	for i := int64(0); i < numberOfPieces; i++ {
+		request := metadataMessage{MsgType: metadataRequest, Piece: i}
+		if err := sendExtended(conn, byte(peerMetadataId), request); err != nil {
+			return nil, err
+		}
+		payload, err := waitForExtended(conn, localMetadataId)
+		if err != nil {
+			return nil, err
+		}
+		response := metadataMessage{}
+		decoder := bencode.NewDecoder(bytes.NewReader(payload))
+		decoder.SetLimits(peerMessageLimits)
+		if err := decoder.DecodeInto(&response); err != nil {
+			return nil, fmt.Errorf("invalid ut_metadata message: %w", err)
+		}
+		switch {
+		case response.MsgType == metadataReject:
+			return nil, fmt.Errorf("peer rejected metadata piece %d", i)
+		case response.MsgType != metadataData || response.Piece != i:
+			return nil, fmt.Errorf("expected metadata piece %d, received %+v", i, response)
+		}
+		// The piece follows the dictionary; all but the last are full size.
+		data := payload[decoder.Offset():]
+		expected := size - i*metadataPieceSize
+		if expected > metadataPieceSize {
+			expected = metadataPieceSize
+		}
+		if int64(len(data)) != expected {
+			return nil, fmt.Errorf("metadata piece %d is %d bytes, expected %d", i, len(data), expected)
+		}
+		metadata = append(metadata, data...)
+	}
+
+	if hash := sha1.Sum(metadata); magnet.InfoHash != nil && !bytes.Equal(hash[:], magnet.InfoHash) {
+		return nil, fmt.Errorf("metadata does not match the info hash")
+	}
+	if hash := sha256.Sum256(metadata); magnet.InfoHashV2 != nil && !bytes.Equal(hash[:], magnet.InfoHashV2) {
+		return nil, fmt.Errorf("metadata does not match the v2 info hash")
+	}
+	return metadata, nil
+}
+
+// magnetSwarmHash returns the 20-byte info hash peers and trackers know
+// the torrent by: the v1 hash, or else the v2 hash truncated.
+func magnetSwarmHash(magnet Magnet) []byte {
+	if magnet.InfoHash != nil {
+		return magnet.InfoHash
+	}
+	return magnet.InfoHashV2[:20]
+}
+
+// fetchMetadataFromPeers tries each peer in turn until one supplies the
+// metadata.
+func fetchMetadataFromPeers(peers []string, magnet Magnet) (bencode.RawMessage, error) {
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers to fetch metadata from")
+	}
+	var failures []string
+	for _, address := range peers {
+		metadata, err := fetchMetadata(address, magnet)
+		if err == nil {
+			return metadata, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", address, err))
+	}
+	return nil, fmt.Errorf("no peer supplied the metadata: %s", strings.Join(failures, "; "))
+}
+
+// torrentInfoFromMagnet returns what is known of a torrent before its
+// metadata arrives: the info hash and the trackers, each in its own tier.
+func torrentInfoFromMagnet(magnet Magnet) TorrentInfo {
+	infoHash := magnetSwarmHash(magnet)
+	torrentInfo := TorrentInfo{
+		InfoHash:    fmt.Sprintf("%x", infoHash),
+		RawInfoHash: infoHash,
+	}
+	for _, tracker := range magnet.Trackers {
+		torrentInfo.AnnounceList = append(torrentInfo.AnnounceList, []string{tracker})
+	}
+	if len(magnet.Trackers) > 0 {
+		torrentInfo.Announce = magnet.Trackers[0]
+	}
+	return torrentInfo
+}
+
+// metadataFromMagnet fetches the metadata of the torrent a magnet link
+// names from trackerPeers, the peers its trackers listed, or from the
+// magnet's own peers when it has no trackers. The result has the magnet's
+// trackers and can be saved as a .torrent file.
+func metadataFromMagnet(magnet Magnet, trackerPeers []string) (Metadata, error) {
+	torrentInfo := torrentInfoFromMagnet(magnet)
+	// Until the metadata arrives we can't tell whether the torrent is
+	// private, so a magnet with trackers only gets its metadata from the
+	// peers they hand out. Contacting its x.pe peers could leak our
+	// interest in a private swarm.
+	peers := trackerPeers
+	if len(magnet.Trackers) == 0 {
+		peers = magnet.Peers
+	}
+	info, err := fetchMetadataFromPeers(peers, magnet)
+	if err != nil {
+		return Metadata{}, err
+	}
+	metadata := Metadata{Info: info}
+	if len(magnet.WebSeeds) > 0 {
+		if metadata.URLList, err = bencode.Marshal(magnet.WebSeeds); err != nil {
+			return Metadata{}, err
+		}
+	}
+	if len(torrentInfo.AnnounceList) > 0 {
+		metadata.Announce = torrentInfo.Announce
+		if len(torrentInfo.AnnounceList) > 1 {
+			metadata.AnnounceList = torrentInfo.AnnounceList
+		}
+	}
+	return metadata, nil
+}
+
+// loadTorrent reads the torrent named by source, a .torrent path or a
+// magnet link, and returns it along with peers to download it from. The
+// download is announced to the torrent's trackers as started; the returned
+// announcer, nil when there are no trackers, is for the announces that
+// follow.
+func loadTorrent(source string) (TorrentInfo, *Announcer, []string, error) {
+	if strings.HasPrefix(source, "magnet:") {
+		magnet, err := parseMagnet(source)
+		if err != nil {
+			return TorrentInfo{}, nil, nil, err
+		}
+		// One announcer covers the whole download: its started announce
+		// finds the peers the metadata comes from, and it is updated
+		// with the metadata for the announces that follow.
+		var announcer *Announcer
+		var peers []string
+		if len(magnet.Trackers) > 0 {
+			announcer, peers, err = startAnnouncer(torrentInfoFromMagnet(magnet))
+			if err != nil {
+				return TorrentInfo{}, nil, nil, fmt.Errorf("unable to fetch tracker data: %w", err)
+			}
+		}
+		metadata, err := metadataFromMagnet(magnet, peers)
+		if err != nil {
+			announceStopped(announcer)
+			return TorrentInfo{}, nil, nil, err
+		}
+		torrentInfo, err := torrentInfoFromMetadata(metadata)
+		if err != nil {
+			announceStopped(announcer)
+			return TorrentInfo{}, nil, nil, err
+		}
+		if announcer != nil {
+			announcer.SetTorrentInfo(torrentInfo)
+		}
+		if torrentInfo.allowsDiscovery(discoveryMagnet) {
+			peers = mergePeers(peers, magnet.Peers)
+		}
+		return torrentInfo, announcer, peers, nil
+	}
+
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return TorrentInfo{}, nil, nil, err
+	}
+	torrentInfo, err := getTorrentInfo(string(content))
+	if err != nil {
+		return TorrentInfo{}, nil, nil, err
+	}
+	announcer, peers, err := startAnnouncer(torrentInfo)
+	if err != nil {
+		// Web seeds can stand in for the swarm when no tracker answers.
+		if len(torrentInfo.WebSeeds) == 0 {
+			return TorrentInfo{}, nil, nil, fmt.Errorf("unable to fetch tracker data: %w", err)
+		}
+		log.Printf("Unable to fetch tracker data, using web seeds only: %v", err)
+	}
+	return torrentInfo, announcer, peers, nil
+}
+
+// startAnnouncer announces a download of torrentInfo as started and
+// returns the peers the tracker lists. The announcer is nil when the
+// torrent has no trackers, and is returned even if the announce failed.
+func startAnnouncer(torrentInfo TorrentInfo) (*Announcer, []string, error) {
+	announcer := NewAnnouncer(torrentInfo)
+	if !announcer.HasTrackers() {
+		return nil, nil, fmt.Errorf("torrent has no trackers")
+	}
+	trackerResponse, err := announcer.Announce(EventStarted)
+	if err != nil {
+		return announcer, nil, err
+	}
+	peers, err := getPeersList(trackerResponse)
+	if err != nil {
+		return announcer, nil, err
+	}
+	return announcer, peerStrings(peers), nil
+}
+
+// mergePeers appends the peers of more that aren't in peers already.
+func mergePeers(peers, more []string) []string {
+	seen := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		seen[peer] = true
+	}
+	for _, peer := range more {
+		if !seen[peer] {
+			seen[peer] = true
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMetadataFromMagnetPeers(t *testing.T) {
+	// The x.pe peer accepts connections and counts them; it never answers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	contacted := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			select {
+			case contacted <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// With trackers, the magnet's peers aren't asked even when no tracker
+	// peer is left to try.
+	magnet := Magnet{
+		InfoHash: make([]byte, 20),
+		Trackers: []string{"http://tracker.example/announce"},
+		Peers:    []string{listener.Addr().String()},
+	}
+	if _, err := metadataFromMagnet(magnet, nil); err == nil {
+		t.Fatal("metadata fetched from no peers")
+	}
+	select {
+	case <-contacted:
+		t.Error("x.pe peer of a magnet with trackers was contacted")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Without trackers they are all there is.
+	magnet.Trackers = nil
+	if _, err := metadataFromMagnet(magnet, nil); err == nil {
+		t.Fatal("metadata fetched from a peer that never answered")
+	}
+	select {
+	case <-contacted:
+	case <-time.After(time.Second):
+		t.Error("x.pe peer of a magnet without trackers was not contacted")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// httpMaxScrape is how many info hashes go in one HTTP scrape, to keep the
+// URL to a length trackers accept.
+const httpMaxScrape = 50
+
+// ScrapeStats are a tracker's counts for one torrent.
+type ScrapeStats struct {
+	// Complete is the number of seeders.
+	Complete int `bencode:"complete"`
+	// Downloaded is how many times the torrent was downloaded in full.
+	Downloaded int `bencode:"downloaded"`
+	// Incomplete is the number of leechers.
+	Incomplete int `bencode:"incomplete"`
+	// Name is the torrent's name, which only some HTTP trackers send.
+	Name string `bencode:"name,omitempty"`
+}
+
+// scrapeResponse is the body of an HTTP scrape response, whose files are
+// keyed by raw info hash.
+type scrapeResponse struct {
+	FailureReason string                 `bencode:"failure reason,omitempty"`
+	Files         map[string]ScrapeStats `bencode:"files"`
+}
+
+// runScrape implements the scrape command:
+//
+//	scrape <torrent>...
+//
+// It asks every tracker of each torrent for the torrent's seeders and
+// leechers, without joining the swarm. Torrents sharing a tracker are
+// scraped in one request. It fails if no tracker answered for some
+// torrent.
+func runScrape(args []string) error {
+	flags := flag.NewFlagSet("scrape", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: scrape <torrent>...")
+	}
+
+	torrents := make([]TorrentInfo, flags.NArg())
+	var trackers []string
+	infoHashes := make(map[string][][]byte)
+	for i, path := range flags.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if torrents[i], err = getTorrentInfo(string(content)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, trackerURL := range torrentTrackers(torrents[i]) {
+			if _, ok := infoHashes[trackerURL]; !ok {
+				trackers = append(trackers, trackerURL)
+			}
+			infoHashes[trackerURL] = append(infoHashes[trackerURL], torrents[i].RawInfoHash)
+		}
+	}
+
+	results := make(map[string]map[string]ScrapeStats, len(trackers))
+	failures := make(map[string]error)
+	for _, trackerURL := range trackers {
+		results[trackerURL], failures[trackerURL] = scrapeTracker(trackerURL, infoHashes[trackerURL])
+	}
+
+	failed := 0
+	for i, path := range flags.Args() {
+		answered := false
+		for _, trackerURL := range torrentTrackers(torrents[i]) {
+			if err := failures[trackerURL]; err != nil {
+				fmt.Printf("%s: %s: %v\n", path, trackerURL, err)
+				continue
+			}
+			stats, ok := results[trackerURL][string(torrents[i].RawInfoHash)]
+			if !ok {
+				fmt.Printf("%s: %s: not tracked\n", path, trackerURL)
+				continue
+			}
+			answered = true
+			fmt.Printf("%s: %s: %d seeders, %d leechers, %d downloads\n", path, trackerURL, stats.Complete, stats.Incomplete, stats.Downloaded)
+		}
+		if !answered {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("no tracker answered for %d of %d torrents", failed, flags.NArg())
+	}
+	return nil
+}
+
+// torrentTrackers lists the distinct trackers of a torrent, tier by tier.
+func torrentTrackers(torrentInfo TorrentInfo) []string {
+	var trackers []string
+	seen := make(map[string]bool)
+	for _, tier := range newTrackerTiers(torrentInfo) {
+		for _, trackerURL := range tier {
+			if !seen[trackerURL] {
+				seen[trackerURL] = true
+				trackers = append(trackers, trackerURL)
+			}
+		}
+	}
+	return trackers
+}
+
+// scrapeTracker asks the tracker announcing at announceURL for the stats
+// of the torrents with infoHashes. The result is keyed by raw info hash
+// and leaves out torrents the tracker doesn't know.
+func scrapeTracker(announceURL string, infoHashes [][]byte) (map[string]ScrapeStats, error) {
+	if strings.HasPrefix(announceURL, "udp://") {
+		stats, err := scrapeUDP(announceURL, infoHashes)
+		if err != nil {
+			return nil, err
+		}
+		// UDP trackers answer for every torrent, with zeros for unknown ones.
+		results := make(map[string]ScrapeStats, len(stats))
+		for i, s := range stats {
+			results[string(infoHashes[i])] = s
+		}
+		return results, nil
+	}
+
+	trackerURL, err := scrapeURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]ScrapeStats, len(infoHashes))
+	for len(infoHashes) > 0 {
+		batch := infoHashes
+		if len(batch) > httpMaxScrape {
+			batch = batch[:httpMaxScrape]
+		}
+		files, err := scrapeHTTP(trackerURL, batch)
+		if err != nil {
+			return nil, err
+		}
+		for infoHash, stats := range files {
+			results[infoHash] = stats
+		}
+		infoHashes = infoHashes[len(batch):]
+	}
+	return results, nil
+}
+
+// scrapeURL derives an HTTP tracker's scrape URL from its announce URL by
+// the convention of BEP 48: the last path component must begin with
+// "announce", which becomes "scrape". Other trackers don't support scrape.
+func scrapeURL(announceURL string) (string, error) {
+	parsed, err := url.Parse(announceURL)
+	if err != nil {
+		return "", err
+	}
+	// The path is rewritten as sent, so an escaped slash doesn't count as
+	// the start of the last component.
+	path := parsed.EscapedPath()
+	slash := strings.LastIndex(path, "/")
+	if slash < 0 || !strings.HasPrefix(path[slash+1:], "announce") {
+		return "", fmt.Errorf("tracker does not support scrape")
+	}
+	parsed.RawPath = path[:slash+1] + "scrape" + strings.TrimPrefix(path[slash+1:], "announce")
+	if parsed.Path, err = url.PathUnescape(parsed.RawPath); err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+// scrapeHTTP sends one scrape request for infoHashes to trackerURL.
+func scrapeHTTP(trackerURL string, infoHashes [][]byte) (map[string]ScrapeStats, error) {
+	params := url.Values{}
+	for _, infoHash := range infoHashes {
+		params.Add("info_hash", string(infoHash))
+	}
+	separator := "?"
+	if strings.Contains(trackerURL, "?") {
+		separator = "&"
+	}
+	resp, err := trackerClient.Get(trackerURL + separator + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := scrapeResponse{}
+	decoder := newTrackerDecoder(resp.Body)
+	decodeErr := decoder.DecodeInto(&response)
+	if response.FailureReason != "" {
+		return nil, &TrackerError{URL: trackerURL, Reason: response.FailureReason}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker returned %s", resp.Status)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid scrape response: %w", decodeErr)
+	}
+	return response.Files, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestScrapeURL(t *testing.T) {
+	// The examples of BEP 48, and a few more.
+	tests := []struct {
+		announce string
+		want     string // empty when the tracker doesn't support scrape
+	}{
+		{"http://example.com/announce", "http://example.com/scrape"},
+		{"http://example.com/x/announce", "http://example.com/x/scrape"},
+		{"http://example.com/announce.php", "http://example.com/scrape.php"},
+		{"http://example.com/a", ""},
+		{"http://example.com/announce?x2%0644", "http://example.com/scrape?x2%0644"},
+		{"http://example.com/announce?x=2/4", "http://example.com/scrape?x=2/4"},
+		{"http://example.com/x%064announce", ""},
+		{"https://example.com:8443/tr/announce?passkey=abc", "https://example.com:8443/tr/scrape?passkey=abc"},
+		{"http://example.com/announce/x", ""},
+		{"http://example.com/x%2Fannounce", ""},
+		{"http://example.com/a%2Fb/announce", "http://example.com/a%2Fb/scrape"},
+		{"http://example.com", ""},
+		{"http://example.com/", ""},
+		{"udp://example.com:80", ""},
+		{"http://example.com/%zz/announce", ""},
+	}
+	for _, tt := range tests {
+		got, err := scrapeURL(tt.announce)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("scrapeURL(%q) = %q, want an error", tt.announce, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("scrapeURL(%q) = %q, %v; want %q", tt.announce, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TrackerResponse struct {
+	// FailureReason is set instead of everything else when the tracker
+	// refuses the announce.
+	FailureReason  string `bencode:"failure reason,omitempty"`
+	WarningMessage string `bencode:"warning message,omitempty"`
+	Interval       int    `bencode:"interval"`
+	MinInterval    int    `bencode:"min interval,omitempty"`
+	// TrackerID is to be sent back on later announces to the same tracker.
+	TrackerID  string `bencode:"tracker id,omitempty"`
+	Complete   int    `bencode:"complete,omitempty"`
+	Incomplete int    `bencode:"incomplete,omitempty"`
+	// Peers is a compact string of IPv4 peers or a list of dictionaries.
+	Peers bencode.RawMessage `bencode:"peers"`
+	// Peers6 is a compact string of IPv6 peers (BEP 7).
+	Peers6 string `bencode:"peers6,omitempty"`
+	// ExternalIP is our address as the tracker sees it, 4 or 16 bytes
+	// (BEP 24).
+	ExternalIP string `bencode:"external ip,omitempty"`
+	// RetryIn comes with a failure: minutes to wait, or "never" (BEP 31).
+	RetryIn bencode.RawMessage `bencode:"retry in,omitempty"`
+}
+
+// trackerTimeout bounds a single announce, so a dead tracker fails over to
+// the next one instead of hanging.
+const trackerTimeout = 15 * time.Second
+
+var trackerClient = &http.Client{Timeout: trackerTimeout}
+
+// trackerRand picks announce keys, UDP transaction IDs and the order of
+// trackers within tiers. Announcers may use it from several goroutines, so
+// its source is locked.
+var trackerRand = rand.New(&lockedSource{source: rand.NewSource(time.Now().UnixNano()).(rand.Source64)})
+
+// lockedSource is a rand.Source64 that is safe for concurrent use.
+type lockedSource struct {
+	mu     sync.Mutex
+	source rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Seed(seed)
+}
+
+func getTrackerResponse(filePath string) ([]PeerAddress, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	fileContentString := string(content)
+	torrentInfo, err := getTorrentInfo(fileContentString)
+	if err != nil {
+		return nil, err
+	}
+	return getPeers(torrentInfo)
+}
+
+// getPeers announces to the torrent's trackers and returns the peers the
+// first one to respond lists.
+func getPeers(torrentInfo TorrentInfo) ([]PeerAddress, error) {
+	trackerResponse, err := NewAnnouncer(torrentInfo).Announce(EventNone)
+	if err != nil {
+		return nil, err
+	}
+
+	return getPeersList(trackerResponse)
+
+}
+
+// discoveryMethod is a way of finding peers.
+type discoveryMethod int
+
+const (
+	discoveryTracker discoveryMethod = iota
+	// discoveryMagnet is the x.pe peers of a magnet link.
+	discoveryMagnet
+)
+
+// allowsDiscovery reports whether peers may be found with method. A
+// private torrent (BEP 27) only gets peers from its own trackers, so that
+// nothing about its swarm leaks elsewhere.
+func (t TorrentInfo) allowsDiscovery(method discoveryMethod) bool {
+	return !t.Private || method == discoveryTracker
+}
+
+// trackerTiers holds a torrent's trackers grouped into BEP 12 tiers.
+type trackerTiers [][]string
+
+// newTrackerTiers builds the tiers from announce-list, falling back to the
+// single announce URL when there is none. Trackers are shuffled within
+// each tier, as BEP 12 asks clients to do.
+func newTrackerTiers(torrentInfo TorrentInfo) trackerTiers {
+	var tiers trackerTiers
+	for _, tier := range torrentInfo.AnnounceList {
+		var urls []string
+		for _, trackerURL := range tier {
+			if trackerURL != "" {
+				urls = append(urls, trackerURL)
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		trackerRand.Shuffle(len(urls), func(i, j int) { urls[i], urls[j] = urls[j], urls[i] })
+		tiers = append(tiers, urls)
+	}
+	if len(tiers) == 0 && torrentInfo.Announce != "" {
+		tiers = trackerTiers{{torrentInfo.Announce}}
+	}
+	return tiers
+}
+
+// announce tries each tracker in order, tier by tier, until one responds.
+// The tracker that answers moves to the front of its tier so that later
+// announces try it first. If every tracker fails and one of them refused
+// the announce, the returned error wraps its *TrackerError.
+func (tiers trackerTiers) announce(announceTo func(trackerURL string) (TrackerResponse, error)) (TrackerResponse, error) {
+	if len(tiers) == 0 {
+		return TrackerResponse{}, fmt.Errorf("torrent has no trackers")
+	}
+	var failures []string
+	var refusal error
+	for _, tier := range tiers {
+		for i, trackerURL := range tier {
+			trackerResponse, err := announceTo(trackerURL)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", trackerURL, err))
+				var trackerErr *TrackerError
+				if refusal == nil && errors.As(err, &trackerErr) {
+					refusal = trackerErr
+				}
+				continue
+			}
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = trackerURL
+			return trackerResponse, nil
+		}
+	}
+	if refusal != nil && len(failures) == 1 {
+		return TrackerResponse{}, refusal
+	}
+	return TrackerResponse{}, &announceError{failures: failures, refusal: refusal}
+}
+
+// announceError is the failure of every tracker of a torrent.
+type announceError struct {
+	failures []string
+	// refusal is the first *TrackerError among the failures, if any.
+	refusal error
+}
+
+func (e *announceError) Error() string {
+	return "no tracker responded: " + strings.Join(e.failures, "; ")
+}
+
+func (e *announceError) Unwrap() error {
+	return e.refusal
+}
+
+// announceRequest is what an announce tells a tracker, whatever protocol
+// it speaks.
+type announceRequest struct {
+	InfoHash   []byte
+	PeerID     string
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      AnnounceEvent
+	// Key lets the tracker recognise us if our address changes.
+	Key       uint32
+	NumWant   int
+	TrackerID string
+	IPv6      string
+}
+
+// httpParams returns the query of the request for an HTTP tracker.
+func (r announceRequest) httpParams() url.Values {
+	params := url.Values{}
+	params.Add("info_hash", string(r.InfoHash))
+	params.Add("peer_id", r.PeerID)
+	params.Add("port", strconv.Itoa(r.Port))
+	params.Add("uploaded", strconv.FormatInt(r.Uploaded, 10))
+	params.Add("downloaded", strconv.FormatInt(r.Downloaded, 10))
+	params.Add("left", strconv.FormatInt(r.Left, 10))
+	params.Add("compact", strconv.Itoa(1))
+	params.Add("numwant", strconv.Itoa(r.NumWant))
+	params.Add("key", fmt.Sprintf("%08x", r.Key))
+	if r.Event != EventNone {
+		params.Add("event", string(r.Event))
+	}
+	if r.TrackerID != "" {
+		params.Add("trackerid", r.TrackerID)
+	}
+	if r.IPv6 != "" {
+		params.Add("ipv6", r.IPv6)
+	}
+	return params
+}
+
+// announceToTracker sends one announce request to trackerURL, over UDP for
+// udp:// URLs and HTTP otherwise. A failure reason in the response becomes
+// a *TrackerError.
+func announceToTracker(trackerURL string, request announceRequest) (TrackerResponse, error) {
+	if strings.HasPrefix(trackerURL, "udp://") {
+		return announceUDP(trackerURL, request)
+	}
+	params := request.httpParams()
+	separator := "?"
+	if strings.Contains(trackerURL, "?") {
+		separator = "&"
+	}
+	requestUrl := trackerURL + separator + params.Encode()
+	resp, err := trackerClient.Get(requestUrl)
+	if err != nil {
+		return TrackerResponse{}, err
+	}
+	defer resp.Body.Close()
+	trackerResponse, err := decodeTrackerResponse(resp.Body)
+	// Some trackers send their failure reason with an error status.
+	if trackerResponse.FailureReason != "" {
+		return TrackerResponse{}, newTrackerError(trackerURL, trackerResponse)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return TrackerResponse{}, fmt.Errorf("tracker returned %s", resp.Status)
+	}
+	return trackerResponse, err
+}
+
+// trackerLimits bounds what we accept from a tracker. Compact peer lists
+// are 6 bytes a peer, so a few MiB leaves plenty of headroom.
+var trackerLimits = bencode.Limits{
+	MaxDepth:     16,
+	MaxStringLen: 1 << 20,
+	MaxEntries:   10000,
+	MaxBytes:     4 << 20,
+}
+
+// decodeTrackerResponse reads an announce response body, enforcing
+// trackerLimits so a hostile tracker can't make us buffer unbounded input.
+func decodeTrackerResponse(body io.Reader) (TrackerResponse, error) {
+	trackerResponse := TrackerResponse{}
+	decoder := newTrackerDecoder(body)
+	if err := decoder.DecodeInto(&trackerResponse); err != nil {
+		return TrackerResponse{}, fmt.Errorf("invalid tracker response: %w", err)
+	}
+	return trackerResponse, nil
+}
+
+// PeerAddress is a peer a tracker listed.
+type PeerAddress struct {
+	// Host is an IP address or, in non-compact lists, possibly a host name.
+	Host string
+	Port uint16
+	// ID is the peer's id when the tracker sent it, which only
+	// non-compact lists do.
+	ID []byte
+}
+
+// String returns host:port, with IPv6 addresses in brackets.
+func (p PeerAddress) String() string {
+	return net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port)))
+}
+
+// trackerPeer is an entry of a non-compact peer list.
+type trackerPeer struct {
+	PeerID string `bencode:"peer id"`
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+}
+
+// newTrackerDecoder returns a decoder for a tracker response body, with
+// trackerLimits.
+func newTrackerDecoder(body io.Reader) *bencode.Decoder {
+	decoder := bencode.NewDecoder(body)
+	decoder.SetLimits(trackerLimits)
+	return decoder
+}
+
+// getPeersList returns the peers of a tracker response, which lists them
+// as a compact string of IPv4 addresses and ports or as a list of
+// dictionaries, and possibly also as a compact string of IPv6 ones.
+func getPeersList(trackerResponse TrackerResponse) ([]PeerAddress, error) {
+	var peersList []PeerAddress
+	var compact string
+	if len(trackerResponse.Peers) > 0 && bencode.Unmarshal(trackerResponse.Peers, &compact) != nil {
+		var peers []trackerPeer
+		if err := bencode.Unmarshal(trackerResponse.Peers, &peers); err != nil {
+			return nil, fmt.Errorf("peers is neither a compact string nor a list of peers")
+		}
+		for _, peer := range peers {
+			if peer.IP == "" || peer.Port <= 0 || peer.Port > 65535 {
+				continue
+			}
+			peerAddress := PeerAddress{Host: peer.IP, Port: uint16(peer.Port)}
+			if peer.PeerID != "" {
+				peerAddress.ID = []byte(peer.PeerID)
+			}
+			peersList = append(peersList, peerAddress)
+		}
+	}
+	peersList = append(peersList, compactPeers(compact, net.IPv4len)...)
+	peersList = append(peersList, compactPeers(trackerResponse.Peers6, net.IPv6len)...)
+	return peersList, nil
+}
+
+// compactPeers splits a compact peer list into its addresses, each ipLength
+// bytes of IP followed by a 2 byte port.
+func compactPeers(peers string, ipLength int) []PeerAddress {
+	var peersList []PeerAddress
+	byteArr := []byte(peers)
+	for i := 0; i+ipLength+2 <= len(byteArr); i += ipLength + 2 {
+		peersList = append(peersList, PeerAddress{
+			Host: net.IP(byteArr[i : i+ipLength]).String(),
+			Port: binary.BigEndian.Uint16(byteArr[i+ipLength : i+ipLength+2]),
+		})
+	}
+	return peersList
+}
+
+// peerStrings returns the host:port addresses of peers.
+func peerStrings(peers []PeerAddress) []string {
+	addresses := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		addresses = append(addresses, peer.String())
+	}
+	return addresses
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeTrackers answers announces for the trackers in answers and fails
+// the rest, recording the order they were tried in.
+type fakeTrackers struct {
+	answers map[string]int
+	refuse  map[string]bool
+	tried   []string
+}
+
+func (f *fakeTrackers) announceTo(trackerURL string) (TrackerResponse, error) {
+	f.tried = append(f.tried, trackerURL)
+	if interval, ok := f.answers[trackerURL]; ok {
+		return TrackerResponse{Interval: interval}, nil
+	}
+	if f.refuse[trackerURL] {
+		return TrackerResponse{}, &TrackerError{URL: trackerURL, Reason: "refused"}
+	}
+	return TrackerResponse{}, errors.New("unreachable")
+}
+
+func TestTrackerTiersAnnounce(t *testing.T) {
+	tiers := trackerTiers{{"a1", "a2"}, {"b1", "b2", "b3"}}
+	trackers := &fakeTrackers{answers: map[string]int{"b3": 60}}
+
+	// Every tracker of the first tier fails, so the second is tried, and
+	// b3 answers after b1 and b2 fail.
+	response, err := tiers.announce(trackers.announceTo)
+	if err != nil || response.Interval != 60 {
+		t.Fatalf("announce = %+v, %v", response, err)
+	}
+	if want := []string{"a1", "a2", "b1", "b2", "b3"}; !reflect.DeepEqual(trackers.tried, want) {
+		t.Errorf("tried %q, want %q", trackers.tried, want)
+	}
+	// b3 moves to the front of its tier; the first tier keeps its order.
+	if want := (trackerTiers{{"a1", "a2"}, {"b3", "b1", "b2"}}); !reflect.DeepEqual(tiers, want) {
+		t.Errorf("tiers = %q, want %q", tiers, want)
+	}
+
+	trackers.tried = nil
+	if _, err := tiers.announce(trackers.announceTo); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a1", "a2", "b3"}; !reflect.DeepEqual(trackers.tried, want) {
+		t.Errorf("second announce tried %q, want %q", trackers.tried, want)
+	}
+}
+
+func TestTrackerTiersAnnounceFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   trackerTiers
+		refuse  map[string]bool
+		bare    bool // the error is the *TrackerError itself
+		refused bool // the error wraps a *TrackerError
+	}{
+		{"one tracker refuses", trackerTiers{{"a"}}, map[string]bool{"a": true}, true, true},
+		{"one tracker unreachable", trackerTiers{{"a"}}, nil, false, false},
+		{"refusal among failures", trackerTiers{{"a"}, {"b"}}, map[string]bool{"b": true}, false, true},
+		{"no refusal", trackerTiers{{"a", "b"}}, nil, false, false},
+	}
+	for _, tt := range tests {
+		trackers := &fakeTrackers{refuse: tt.refuse}
+		_, err := tt.tiers.announce(trackers.announceTo)
+		if err == nil {
+			t.Errorf("%s: announce succeeded", tt.name)
+			continue
+		}
+		var trackerErr *TrackerError
+		if refused := errors.As(err, &trackerErr); refused != tt.refused {
+			t.Errorf("%s: error %v wraps a *TrackerError: %v, want %v", tt.name, err, refused, tt.refused)
+		}
+		if _, bare := err.(*TrackerError); bare != tt.bare {
+			t.Errorf("%s: error is a %T, want a bare *TrackerError: %v", tt.name, err, tt.bare)
+		}
+	}
+
+	if _, err := (trackerTiers{}).announce((&fakeTrackers{}).announceTo); err == nil {
+		t.Error("announce with no trackers succeeded")
+	}
+}
+
+func TestGetPeersList(t *testing.T) {
+	v6 := string(net.ParseIP("2001:db8::1")) + "\x1a\xe1"
+	tests := []struct {
+		name   string
+		peers  string // bencoded
+		peers6 string
+		want   []string
+	}{
+		{"compact", "12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50", "", []string{"10.0.0.1:6881", "192.168.1.2:80"}},
+		{"compact with a trailing partial peer", "8:\x0a\x00\x00\x01\x1a\xe1\x01\x02", "", []string{"10.0.0.1:6881"}},
+		{"empty", "0:", "", []string{}},
+		{"missing", "", "", []string{}},
+		{"dictionaries",
+			"ld2:ip8:10.0.0.17:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881eed2:ip15:tracker.example4:porti80eee",
+			"", []string{"10.0.0.1:6881", "tracker.example:80"}},
+		{"dictionary with an IPv6 address", "ld2:ip3:::14:porti51413eee", "", []string{"[::1]:51413"}},
+		{"ports out of range",
+			"ld2:ip8:10.0.0.14:porti0eed2:ip8:10.0.0.24:porti65536eed2:ip8:10.0.0.34:porti-1eed2:ip8:10.0.0.44:porti65535eee",
+			"", []string{"10.0.0.4:65535"}},
+		{"dictionary without an ip", "ld4:porti6881eee", "", []string{}},
+		{"peers6", "0:", v6, []string{"[2001:db8::1]:6881"}},
+		{"peers and peers6", "6:\x0a\x00\x00\x01\x1a\xe1", v6 + "\x01", []string{"10.0.0.1:6881", "[2001:db8::1]:6881"}},
+	}
+	for _, tt := range tests {
+		peers, err := getPeersList(TrackerResponse{Peers: bencode.RawMessage(tt.peers), Peers6: tt.peers6})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := peerStrings(peers); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: peers %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	peers, err := getPeersList(TrackerResponse{Peers: bencode.RawMessage("ld2:ip8:10.0.0.17:peer id3:abc4:porti1eee")})
+	if err != nil || len(peers) != 1 || string(peers[0].ID) != "abc" {
+		t.Errorf("peer ids = %+v, %v", peers, err)
+	}
+	if _, err := getPeersList(TrackerResponse{Peers: bencode.RawMessage("i5e")}); err == nil {
+		t.Error("peers of the wrong type accepted")
+	}
+}
+
+func TestPeerAddressString(t *testing.T) {
+	for _, tt := range []struct {
+		peer PeerAddress
+		want string
+	}{
+		{PeerAddress{Host: "10.0.0.1", Port: 6881}, "10.0.0.1:6881"},
+		{PeerAddress{Host: "::1", Port: 80}, "[::1]:80"},
+		{PeerAddress{Host: "2001:db8::1", Port: 65535}, "[2001:db8::1]:65535"},
+		{PeerAddress{Host: "tracker.example", Port: 1}, "tracker.example:1"},
+	} {
+		if got := tt.peer.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.peer, got, tt.want)
+		}
+	}
+}
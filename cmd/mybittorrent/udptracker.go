@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UDP tracker actions (BEP 15).
+const (
+	udpConnect  uint32 = 0
+	udpAnnounce uint32 = 1
+	udpScrape   uint32 = 2
+	udpError    uint32 = 3
+)
+
+// udpProtocolID starts every connect request.
+const udpProtocolID = 0x41727101980
+
+// udpConnectionLifetime is how long a connection ID may be used for.
+const udpConnectionLifetime = time.Minute
+
+// udpMaxScrape is how many info hashes fit in one scrape request.
+const udpMaxScrape = 74
+
+var (
+	// udpBaseTimeout is how long the first attempt at a request waits for
+	// a response; each retransmission waits twice as long as the last.
+	udpBaseTimeout = 15 * time.Second
+	// udpMaxRetransmissions bounds the retries of a request. BEP 15 allows
+	// up to 8, about an hour in all, but we would rather fail over to the
+	// next tracker.
+	udpMaxRetransmissions = 2
+)
+
+// udpEvents maps announce events to their codes in UDP announces.
+var udpEvents = map[AnnounceEvent]uint32{
+	EventNone:      0,
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+// errUDPTimeout is returned by exchange when no response came in time.
+var errUDPTimeout = errors.New("timed out")
+
+// udpConnections caches connection IDs by tracker address, so requests in
+// quick succession don't each need a connect first.
+var udpConnections = struct {
+	sync.Mutex
+	ids map[string]udpConnection
+}{ids: make(map[string]udpConnection)}
+
+type udpConnection struct {
+	id      uint64
+	expires time.Time
+}
+
+// udpTracker is a socket to one UDP tracker.
+type udpTracker struct {
+	url     string
+	address string
+	conn    net.Conn
+}
+
+// dialUDPTracker opens a socket to the tracker at trackerURL, a
+// udp://host:port URL.
+func dialUDPTracker(trackerURL string) (*udpTracker, error) {
+	parsed, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Port() == "" {
+		return nil, fmt.Errorf("UDP tracker URL %s has no port", trackerURL)
+	}
+	conn, err := net.Dial("udp", parsed.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &udpTracker{url: trackerURL, address: parsed.Host, conn: conn}, nil
+}
+
+func (t *udpTracker) Close() error {
+	return t.conn.Close()
+}
+
+// announceUDP sends one announce request to the UDP tracker at trackerURL.
+func announceUDP(trackerURL string, request announceRequest) (TrackerResponse, error) {
+	tracker, err := dialUDPTracker(trackerURL)
+	if err != nil {
+		return TrackerResponse{}, err
+	}
+	defer tracker.Close()
+	return tracker.announce(request)
+}
+
+// scrapeUDP asks the UDP tracker at trackerURL for the stats of the
+// torrents with infoHashes, in order.
+func scrapeUDP(trackerURL string, infoHashes [][]byte) ([]ScrapeStats, error) {
+	tracker, err := dialUDPTracker(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.Close()
+	var stats []ScrapeStats
+	for len(infoHashes) > 0 {
+		batch := infoHashes
+		if len(batch) > udpMaxScrape {
+			batch = batch[:udpMaxScrape]
+		}
+		batchStats, err := tracker.scrape(batch)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, batchStats...)
+		infoHashes = infoHashes[len(batch):]
+	}
+	return stats, nil
+}
+
+// announce sends an announce and converts the response to the form HTTP
+// trackers use.
+func (t *udpTracker) announce(request announceRequest) (TrackerResponse, error) {
+	// The ip field is left 0, for the tracker to use the packet's source.
+	payload := make([]byte, 82)
+	copy(payload[0:20], request.InfoHash)
+	copy(payload[20:40], request.PeerID)
+	binary.BigEndian.PutUint64(payload[40:48], uint64(request.Downloaded))
+	binary.BigEndian.PutUint64(payload[48:56], uint64(request.Left))
+	binary.BigEndian.PutUint64(payload[56:64], uint64(request.Uploaded))
+	binary.BigEndian.PutUint32(payload[64:68], udpEvents[request.Event])
+	binary.BigEndian.PutUint32(payload[72:76], request.Key)
+	binary.BigEndian.PutUint32(payload[76:80], uint32(int32(request.NumWant)))
+	binary.BigEndian.PutUint16(payload[80:82], uint16(request.Port))
+
+	response, err := t.request(udpAnnounce, payload)
+	if err != nil {
+		return TrackerResponse{}, err
+	}
+	if len(response) < 20 {
+		return TrackerResponse{}, fmt.Errorf("announce response is %d bytes, too short", len(response))
+	}
+	trackerResponse := TrackerResponse{
+		Interval:   int(binary.BigEndian.Uint32(response[8:12])),
+		Incomplete: int(binary.BigEndian.Uint32(response[12:16])),
+		Complete:   int(binary.BigEndian.Uint32(response[16:20])),
+	}
+	// Peers are of the address family we reached the tracker over.
+	peers := string(response[20:])
+	if remote, ok := t.conn.RemoteAddr().(*net.UDPAddr); ok && remote.IP.To4() == nil {
+		trackerResponse.Peers6 = peers
+		return trackerResponse, nil
+	}
+	if trackerResponse.Peers, err = bencode.Marshal(peers); err != nil {
+		return TrackerResponse{}, err
+	}
+	return trackerResponse, nil
+}
+
+// scrape asks for the stats of up to udpMaxScrape torrents.
+func (t *udpTracker) scrape(infoHashes [][]byte) ([]ScrapeStats, error) {
+	payload := make([]byte, 0, 20*len(infoHashes))
+	for _, infoHash := range infoHashes {
+		payload = append(payload, infoHash...)
+	}
+	response, err := t.request(udpScrape, payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 8+12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response is %d bytes, expected %d", len(response), 8+12*len(infoHashes))
+	}
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i := range stats {
+		entry := response[8+12*i:]
+		stats[i] = ScrapeStats{
+			Complete:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Downloaded: int(binary.BigEndian.Uint32(entry[4:8])),
+			Incomplete: int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+	return stats, nil
+}
+
+// request sends action with payload under a current connection ID and
+// returns the response, retransmitting with growing timeouts. The
+// connection ID is renewed when it expires between retransmissions.
+func (t *udpTracker) request(action uint32, payload []byte) ([]byte, error) {
+	for n := 0; n <= udpMaxRetransmissions; n++ {
+		connectionID, err := t.connect()
+		if err != nil {
+			return nil, err
+		}
+		packet := make([]byte, 16, 16+len(payload))
+		binary.BigEndian.PutUint64(packet[0:8], connectionID)
+		binary.BigEndian.PutUint32(packet[8:12], action)
+		packet = append(packet, payload...)
+		response, err := t.exchange(packet, udpBaseTimeout<<uint(n))
+		if err == errUDPTimeout {
+			continue
+		}
+		return response, err
+	}
+	return nil, fmt.Errorf("no response from %s", t.address)
+}
+
+// connect returns a connection ID for the tracker, from the cache while
+// it's fresh.
+func (t *udpTracker) connect() (uint64, error) {
+	udpConnections.Lock()
+	connection, ok := udpConnections.ids[t.address]
+	udpConnections.Unlock()
+	if ok && time.Now().Before(connection.expires) {
+		return connection.id, nil
+	}
+
+	packet := make([]byte, 16)
+	binary.BigEndian.PutUint64(packet[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(packet[8:12], udpConnect)
+	for n := 0; n <= udpMaxRetransmissions; n++ {
+		response, err := t.exchange(packet, udpBaseTimeout<<uint(n))
+		if err == errUDPTimeout {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(response) < 16 {
+			return 0, fmt.Errorf("connect response is %d bytes, too short", len(response))
+		}
+		connection := udpConnection{
+			id:      binary.BigEndian.Uint64(response[8:16]),
+			expires: time.Now().Add(udpConnectionLifetime),
+		}
+		udpConnections.Lock()
+		udpConnections.ids[t.address] = connection
+		udpConnections.Unlock()
+		return connection.id, nil
+	}
+	return 0, fmt.Errorf("no response from %s", t.address)
+}
+
+// exchange sends packet, whose action is at bytes 8 to 12, under a new
+// transaction ID and waits up to timeout for the response with the same
+// transaction ID. Stray packets, such as late answers to earlier attempts,
+// are skipped. An error response becomes a *TrackerError.
+func (t *udpTracker) exchange(packet []byte, timeout time.Duration) ([]byte, error) {
+	action := binary.BigEndian.Uint32(packet[8:12])
+	transactionID := trackerRand.Uint32()
+	binary.BigEndian.PutUint32(packet[12:16], transactionID)
+	if _, err := t.conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	if err := t.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, 64<<10)
+	for {
+		n, err := t.conn.Read(buffer)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, errUDPTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buffer[4:8]) != transactionID {
+			continue
+		}
+		response := append([]byte(nil), buffer[:n]...)
+		switch binary.BigEndian.Uint32(response[0:4]) {
+		case action:
+			return response, nil
+		case udpError:
+			return nil, &TrackerError{URL: t.url, Reason: string(response[8:])}
+		default:
+			return nil, fmt.Errorf("response has action %d, expected %d", binary.BigEndian.Uint32(response[0:4]), action)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// udpStandIn is an in-process UDP tracker.
+type udpStandIn struct {
+	t    *testing.T
+	conn net.PacketConn
+
+	mu sync.Mutex
+	// received holds every packet that arrived, including dropped ones.
+	received [][]byte
+	// drop is how many of the next packets to ignore.
+	drop int
+	// stray makes every response follow a packet with a wrong
+	// transaction ID.
+	stray bool
+	// failure, if set, is the error message announces are answered with.
+	failure      string
+	connectionID uint64
+}
+
+func newUDPStandIn(t *testing.T) *udpStandIn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &udpStandIn{t: t, conn: conn, connectionID: 0x1122334455667788}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+
+	baseTimeout := udpBaseTimeout
+	udpBaseTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { udpBaseTimeout = baseTimeout })
+	return s
+}
+
+func (s *udpStandIn) url() string {
+	return "udp://" + s.conn.LocalAddr().String() + "/announce"
+}
+
+// actions returns the action of each packet received so far.
+func (s *udpStandIn) actions() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var actions []uint32
+	for _, packet := range s.received {
+		actions = append(actions, binary.BigEndian.Uint32(packet[8:12]))
+	}
+	return actions
+}
+
+// set changes the stand-in's behaviour while it serves.
+func (s *udpStandIn) set(change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+}
+
+func (s *udpStandIn) last() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received[len(s.received)-1]
+}
+
+func (s *udpStandIn) serve() {
+	buffer := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		packet := append([]byte(nil), buffer[:n]...)
+		s.mu.Lock()
+		s.received = append(s.received, packet)
+		drop := s.drop > 0
+		if drop {
+			s.drop--
+		}
+		stray, failure, connectionID := s.stray, s.failure, s.connectionID
+		s.mu.Unlock()
+		if drop || n < 16 {
+			continue
+		}
+
+		action := binary.BigEndian.Uint32(packet[8:12])
+		transactionID := binary.BigEndian.Uint32(packet[12:16])
+		if stray {
+			response := make([]byte, 16)
+			binary.BigEndian.PutUint32(response[0:4], action)
+			binary.BigEndian.PutUint32(response[4:8], transactionID+1)
+			s.conn.WriteTo(response, addr)
+		}
+		response := make([]byte, 8)
+		binary.BigEndian.PutUint32(response[0:4], action)
+		binary.BigEndian.PutUint32(response[4:8], transactionID)
+		switch {
+		case action == udpConnect:
+			if binary.BigEndian.Uint64(packet[0:8]) != udpProtocolID {
+				s.t.Errorf("connect has protocol id %x", packet[0:8])
+			}
+			response = append(response, make([]byte, 8)...)
+			binary.BigEndian.PutUint64(response[8:16], connectionID)
+		case binary.BigEndian.Uint64(packet[0:8]) != connectionID:
+			s.t.Errorf("action %d has connection id %x", action, packet[0:8])
+			continue
+		case failure != "":
+			binary.BigEndian.PutUint32(response[0:4], udpError)
+			response = append(response, failure...)
+		case action == udpAnnounce:
+			// Interval, leechers and seeders, then two peers.
+			response = append(response, 0, 0, 0x07, 0x08, 0, 0, 0, 3, 0, 0, 0, 7)
+			response = append(response, 10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2)
+		case action == udpScrape:
+			// Seeders, completed and leechers for each info hash, made
+			// from its first byte.
+			for i := 16; i+20 <= n; i += 20 {
+				b := uint32(packet[i])
+				response = append(response, make([]byte, 12)...)
+				entry := response[len(response)-12:]
+				binary.BigEndian.PutUint32(entry[0:4], b)
+				binary.BigEndian.PutUint32(entry[4:8], b*100)
+				binary.BigEndian.PutUint32(entry[8:12], b+1)
+			}
+		}
+		s.conn.WriteTo(response, addr)
+	}
+}
+
+func testAnnounceRequest() announceRequest {
+	return announceRequest{
+		InfoHash:   bytes.Repeat([]byte{0xab}, 20),
+		PeerID:     "00112233445566778899",
+		Port:       6881,
+		Uploaded:   1,
+		Downloaded: 2,
+		Left:       777,
+		Event:      EventStarted,
+		Key:        0xcafebabe,
+		NumWant:    50,
+	}
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	s := newUDPStandIn(t)
+	response, err := announceToTracker(s.url(), testAnnounceRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.actions(); len(got) != 2 || got[0] != udpConnect || got[1] != udpAnnounce {
+		t.Errorf("actions = %v, want connect then announce", got)
+	}
+
+	packet := s.last()
+	if len(packet) != 98 {
+		t.Fatalf("announce is %d bytes, want 98", len(packet))
+	}
+	fields := []struct {
+		name      string
+		got, want uint64
+	}{
+		{"downloaded", binary.BigEndian.Uint64(packet[56:64]), 2},
+		{"left", binary.BigEndian.Uint64(packet[64:72]), 777},
+		{"uploaded", binary.BigEndian.Uint64(packet[72:80]), 1},
+		{"event", uint64(binary.BigEndian.Uint32(packet[80:84])), 2},
+		{"ip", uint64(binary.BigEndian.Uint32(packet[84:88])), 0},
+		{"key", uint64(binary.BigEndian.Uint32(packet[88:92])), 0xcafebabe},
+		{"num want", uint64(binary.BigEndian.Uint32(packet[92:96])), 50},
+		{"port", uint64(binary.BigEndian.Uint16(packet[96:98])), 6881},
+	}
+	for _, f := range fields {
+		if f.got != f.want {
+			t.Errorf("%s = %d, want %d", f.name, f.got, f.want)
+		}
+	}
+	if !bytes.Equal(packet[16:36], testAnnounceRequest().InfoHash) || string(packet[36:56]) != "00112233445566778899" {
+		t.Errorf("info hash or peer id wrong: %x", packet[16:56])
+	}
+
+	if response.Interval != 0x0708 || response.Incomplete != 3 || response.Complete != 7 {
+		t.Errorf("response = %+v", response)
+	}
+	peers, err := getPeersList(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[0].String() != "10.0.0.1:6881" || peers[1].String() != "10.0.0.2:6882" {
+		t.Errorf("peers = %v", peers)
+	}
+}
+
+func TestUDPSkipsMismatchedTransaction(t *testing.T) {
+	s := newUDPStandIn(t)
+	s.set(func() { s.stray = true })
+	if _, err := announceToTracker(s.url(), testAnnounceRequest()); err != nil {
+		t.Fatal(err)
+	}
+	// The stray packets were skipped rather than retransmitted over.
+	if got := s.actions(); len(got) != 2 {
+		t.Errorf("actions = %v, want one connect and one announce", got)
+	}
+}
+
+func TestUDPErrorAction(t *testing.T) {
+	s := newUDPStandIn(t)
+	s.set(func() { s.failure = "torrent not registered" })
+	_, err := announceToTracker(s.url(), testAnnounceRequest())
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) {
+		t.Fatalf("error = %v, want *TrackerError", err)
+	}
+	if trackerErr.Reason != "torrent not registered" || trackerErr.URL != s.url() {
+		t.Errorf("error = %+v", trackerErr)
+	}
+}
+
+func TestUDPConnectionReuse(t *testing.T) {
+	s := newUDPStandIn(t)
+	for i := 0; i < 2; i++ {
+		if _, err := announceToTracker(s.url(), testAnnounceRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := s.actions(); len(got) != 3 || got[0] != udpConnect || got[1] != udpAnnounce || got[2] != udpAnnounce {
+		t.Errorf("actions = %v, want one connect for both announces", got)
+	}
+
+	// Once the connection ID expires, the next request connects again and
+	// uses the new one.
+	address := s.conn.LocalAddr().String()
+	udpConnections.Lock()
+	connection := udpConnections.ids[address]
+	connection.expires = time.Now().Add(-time.Second)
+	udpConnections.ids[address] = connection
+	udpConnections.Unlock()
+	s.set(func() { s.connectionID = 0x99 })
+	if _, err := announceToTracker(s.url(), testAnnounceRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.actions(); len(got) != 5 || got[3] != udpConnect || got[4] != udpAnnounce {
+		t.Errorf("actions = %v, want a new connect after expiry", got)
+	}
+	if got := binary.BigEndian.Uint64(s.last()[0:8]); got != 0x99 {
+		t.Errorf("announce used connection id %x, want 99", got)
+	}
+}
+
+func TestUDPRetransmission(t *testing.T) {
+	s := newUDPStandIn(t)
+	// The first connect is lost, then, with the connection ID cached, the
+	// first scrape.
+	s.set(func() { s.drop = 1 })
+	start := time.Now()
+	if _, err := scrapeUDP(s.url(), [][]byte{make([]byte, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.actions(); len(got) != 3 || got[0] != udpConnect || got[1] != udpConnect || got[2] != udpScrape {
+		t.Errorf("actions = %v, want connect, connect, scrape", got)
+	}
+	if elapsed := time.Since(start); elapsed < udpBaseTimeout {
+		t.Errorf("retransmitted after %v, before the %v timeout", elapsed, udpBaseTimeout)
+	}
+
+	s.set(func() { s.drop = 1 })
+	if _, err := scrapeUDP(s.url(), [][]byte{make([]byte, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.actions()[3:]; len(got) != 2 || got[0] != udpScrape || got[1] != udpScrape {
+		t.Errorf("actions = %v, want the scrape sent twice", got)
+	}
+}
+
+func TestUDPNoResponse(t *testing.T) {
+	s := newUDPStandIn(t)
+	s.set(func() { s.drop = 1000 })
+	start := time.Now()
+	if _, err := announceToTracker(s.url(), testAnnounceRequest()); err == nil {
+		t.Fatal("announce succeeded with no responses")
+	}
+	if got := len(s.actions()); got != udpMaxRetransmissions+1 {
+		t.Errorf("sent %d connects, want %d", got, udpMaxRetransmissions+1)
+	}
+	// Each retransmission waits twice as long as the one before.
+	want := udpBaseTimeout * time.Duration(1<<uint(udpMaxRetransmissions+1)-1)
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("gave up after %v, want at least %v", elapsed, want)
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	s := newUDPStandIn(t)
+	// More hashes than fit in one request.
+	infoHashes := make([][]byte, udpMaxScrape+6)
+	for i := range infoHashes {
+		infoHashes[i] = make([]byte, 20)
+		infoHashes[i][0] = byte(i)
+	}
+	stats, err := scrapeUDP(s.url(), infoHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != len(infoHashes) {
+		t.Fatalf("got %d stats, want %d", len(stats), len(infoHashes))
+	}
+	for i, got := range stats {
+		want := ScrapeStats{Complete: i, Downloaded: i * 100, Incomplete: i + 1}
+		if got != want {
+			t.Errorf("stats[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if got := s.actions(); len(got) != 3 || got[1] != udpScrape || got[2] != udpScrape {
+		t.Errorf("actions = %v, want connect and two scrapes", got)
+	}
+	if n := len(s.last()); n != 16+20*6 {
+		t.Errorf("second scrape is %d bytes, want %d", n, 16+20*6)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Messages peers use to exchange merkle tree hashes (BEP 52).
+const (
+	hashRequest MessageId = 21
+	hashes      MessageId = 22
+	hashReject  MessageId = 23
+)
+
+// merkleBlockSize is the size of the leaves of BitTorrent v2 (BEP 52)
+// merkle trees. Piece lengths of v2 torrents are powers of two of at least
+// this size.
+const merkleBlockSize = 16 << 10
+
+// addV2 fills in the v2 parts of torrentInfo from info, whose raw encoding
+// is rawInfo. For a v2-only torrent the file list and swarm info hash come
+// from the file tree; a hybrid torrent keeps its v1 file list, which must
+// describe the same files.
+func (t *TorrentInfo) addV2(info MetadataInfo, rawInfo []byte, pieceLayers map[string]string) error {
+	if info.PieceLength < merkleBlockSize || info.PieceLength&(info.PieceLength-1) != 0 {
+		return fmt.Errorf("v2 piece length %d is not a power of two of at least %d", info.PieceLength, merkleBlockSize)
+	}
+	if len(info.FileTree) == 0 {
+		return fmt.Errorf("v2 torrent has no file tree")
+	}
+	files, totalLength, err := getFilesV2(info)
+	if err != nil {
+		return err
+	}
+
+	t.PieceLayers = make(map[string][]byte)
+	for _, f := range files {
+		if f.Length <= info.PieceLength {
+			continue
+		}
+		layer, ok := pieceLayers[string(f.PiecesRoot)]
+		if !ok {
+			// Torrents built from a magnet link have no piece layers;
+			// their pieces are verified with proofs from peers, see
+			// requestPieceProof.
+			continue
+		}
+		if err := checkPieceLayer([]byte(layer), f.PiecesRoot, f.Length, info.PieceLength); err != nil {
+			return fmt.Errorf("file %s: %w", strings.Join(f.Path, "/"), err)
+		}
+		t.PieceLayers[string(f.PiecesRoot)] = []byte(layer)
+	}
+
+	hash := sha256.Sum256(rawInfo)
+	t.MetaVersion = 2
+	t.RawInfoHashV2 = hash[:]
+	t.InfoHashV2 = fmt.Sprintf("%x", hash)
+	if t.Pieces != nil {
+		return t.matchV2Files(files)
+	}
+
+	// The handshake and trackers carry a v2 info hash truncated to 20 bytes.
+	t.RawInfoHash = hash[:20]
+	t.InfoHash = fmt.Sprintf("%x", hash[:20])
+	t.Files = files
+	t.TotalLength = totalLength
+	t.MultiFile = len(files) != 1 || len(files[0].Path) != 1 || files[0].Path[0] != info.Name
+	return nil
+}
+
+// getFilesV2 lists the files of a v2 file tree in tree order. Every file
+// starts on a piece boundary, so Offset counts the unused tail of each
+// file's last piece.
+func getFilesV2(info MetadataInfo) ([]TorrentFile, int64, error) {
+	var files []TorrentFile
+	if err := walkFileTree(info.FileTree, nil, &files); err != nil {
+		return nil, 0, fmt.Errorf("invalid file tree: %w", err)
+	}
+	var offset, totalLength int64
+	for i := range files {
+		files[i].Offset = offset
+		offset += (files[i].Length + info.PieceLength - 1) / info.PieceLength * info.PieceLength
+		totalLength += files[i].Length
+	}
+	return files, totalLength, nil
+}
+
+// walkFileTree appends the files below tree, whose own path is path, to
+// files. A file is a dictionary whose only key is the empty string.
+func walkFileTree(tree map[string]interface{}, path []string, files *[]TorrentFile) error {
+	if entry, ok := tree[""]; ok {
+		if len(tree) != 1 || len(path) == 0 {
+			return fmt.Errorf("file entry mixed with directory entries at %q", strings.Join(path, "/"))
+		}
+		file, ok := entry.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("file %q is not a dictionary", strings.Join(path, "/"))
+		}
+		length, ok := file["length"].(int64)
+		if !ok || length < 0 {
+			return fmt.Errorf("file %q has no valid length", strings.Join(path, "/"))
+		}
+		root, _ := file["pieces root"].([]byte)
+		if length > 0 && len(root) != sha256.Size {
+			return fmt.Errorf("file %q has no valid pieces root", strings.Join(path, "/"))
+		}
+		attr, _ := file["attr"].([]byte)
+		var symlinkPath []string
+		if target, ok := file["symlink path"].([]interface{}); ok {
+			for _, component := range target {
+				if component, ok := component.([]byte); ok {
+					symlinkPath = append(symlinkPath, string(component))
+				}
+			}
+		}
+		f, err := newTorrentFile(path, length, string(attr), symlinkPath)
+		if err != nil {
+			return err
+		}
+		f.PiecesRoot = root
+		*files = append(*files, f)
+		return nil
+	}
+
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		subtree, ok := tree[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("entry %q is not a dictionary", strings.Join(append(path, name), "/"))
+		}
+		childPath := make([]string, len(path), len(path)+1)
+		copy(childPath, path)
+		if err := walkFileTree(subtree, append(childPath, name), files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchV2Files checks that a hybrid torrent's v2 files are all in its v1
+// file list, and records their pieces roots there.
+func (t *TorrentInfo) matchV2Files(files []TorrentFile) error {
+	index := make(map[string]int, len(t.Files))
+	for i, f := range t.Files {
+		index[strings.Join(f.Path, "/")] = i
+	}
+	for _, f := range files {
+		path := strings.Join(f.Path, "/")
+		i, ok := index[path]
+		if !ok || t.Files[i].Length != f.Length {
+			return fmt.Errorf("hybrid torrent v1 and v2 file lists differ at %q", path)
+		}
+		t.Files[i].PiecesRoot = f.PiecesRoot
+	}
+	return nil
+}
+
+// checkPieceLayer checks that layer holds one hash per piece of a file of
+// fileLength bytes and that the hashes combine into root.
+func checkPieceLayer(layer []byte, root []byte, fileLength, pieceLength int64) error {
+	numberOfPieces := (fileLength + pieceLength - 1) / pieceLength
+	if int64(len(layer)) != numberOfPieces*sha256.Size {
+		return fmt.Errorf("piece layer has %d bytes, expected %d", len(layer), numberOfPieces*sha256.Size)
+	}
+	hashes := make([][]byte, numberOfPieces)
+	for i := range hashes {
+		hashes[i] = layer[i*sha256.Size : (i+1)*sha256.Size]
+	}
+	pad := merkleRoot(nil, int(pieceLength/merkleBlockSize), make([]byte, sha256.Size))
+	if !bytes.Equal(merkleRoot(hashes, nextPowerOfTwo(len(hashes)), pad), root) {
+		return fmt.Errorf("piece layer does not match the pieces root")
+	}
+	return nil
+}
+
+// merkleRoot returns the root of the merkle tree whose bottom layer is
+// hashes padded with pad to width entries. width must be a power of two.
+func merkleRoot(hashes [][]byte, width int, pad []byte) []byte {
+	layer := make([][]byte, width)
+	for i := range layer {
+		if i < len(hashes) {
+			layer[i] = hashes[i]
+		} else {
+			layer[i] = pad
+		}
+	}
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			layer[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+func hashPair(left, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
+
+func nextPowerOfTwo(n int) int {
+	width := 1
+	for width < n {
+		width *= 2
+	}
+	return width
+}
+
+// verifyMerkleProof reports whether hash is entry index of the layer of a
+// merkle tree that proof, the sibling hashes from that layer up, leads to
+// root.
+func verifyMerkleProof(hash []byte, index int64, proof [][]byte, root []byte) bool {
+	for _, sibling := range proof {
+		if index%2 == 0 {
+			hash = hashPair(hash, sibling)
+		} else {
+			hash = hashPair(sibling, hash)
+		}
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(hash, root)
+}
+
+// pieceHashV2 returns the merkle hash of one piece of a v2 file: the root
+// of the 16 KiB block hashes, padded to a full piece. A file no longer
+// than one piece is padded only to a power of two of blocks, which makes
+// the result its pieces root.
+func pieceHashV2(data []byte, fileLength, pieceLength int64) []byte {
+	var leaves [][]byte
+	for begin := 0; begin < len(data); begin += merkleBlockSize {
+		end := begin + merkleBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha256.Sum256(data[begin:end])
+		leaves = append(leaves, sum[:])
+	}
+	width := int(pieceLength / merkleBlockSize)
+	if fileLength <= pieceLength {
+		width = nextPowerOfTwo(len(leaves))
+	}
+	return merkleRoot(leaves, width, make([]byte, sha256.Size))
+}
+
+// pieceFile returns the file piece index belongs to in a v2-only torrent,
+// and the index of the piece within that file.
+func (t TorrentInfo) pieceFile(index int) (TorrentFile, int64, bool) {
+	offset := t.pieceOffset(index)
+	for _, f := range t.Files {
+		if f.Length > 0 && f.Offset <= offset && offset < f.Offset+f.Length {
+			return f, (offset - f.Offset) / t.PieceLength, true
+		}
+	}
+	return TorrentFile{}, 0, false
+}
+
+// verifyPieceV2 checks a piece of a v2-only torrent against its piece
+// layer, or for a single-piece file against its pieces root.
+func (t TorrentInfo) verifyPieceV2(index int, data []byte) error {
+	f, filePiece, ok := t.pieceFile(index)
+	if !ok {
+		return fmt.Errorf("piece %d out of range", index)
+	}
+	hash := pieceHashV2(data, f.Length, t.PieceLength)
+	if f.Length <= t.PieceLength {
+		if !bytes.Equal(hash, f.PiecesRoot) {
+			return fmt.Errorf("piece %d does not match the pieces root", index)
+		}
+		return nil
+	}
+	layer, ok := t.PieceLayers[string(f.PiecesRoot)]
+	if !ok {
+		return fmt.Errorf("piece %d: no piece layer for %s", index, strings.Join(f.Path, "/"))
+	}
+	if !bytes.Equal(hash, layer[filePiece*sha256.Size:(filePiece+1)*sha256.Size]) {
+		return fmt.Errorf("piece %d does not match its piece layer", index)
+	}
+	return nil
+}
+
+// verifyPieceProof checks a piece of a v2-only torrent against its file's
+// pieces root using proof, the uncle hashes from the piece layer up, as
+// peers send them in BEP 52 hashes messages. It needs no piece layer.
+func (t TorrentInfo) verifyPieceProof(index int, data []byte, proof [][]byte) error {
+	f, filePiece, ok := t.pieceFile(index)
+	if !ok {
+		return fmt.Errorf("piece %d out of range", index)
+	}
+	hash := pieceHashV2(data, f.Length, t.PieceLength)
+	if !verifyMerkleProof(hash, filePiece, proof, f.PiecesRoot) {
+		return fmt.Errorf("piece %d does not match the pieces root", index)
+	}
+	return nil
+}
+
+// needsPieceProof reports whether piece index of a v2-only torrent can only
+// be verified with a merkle proof, because its file spans several pieces
+// and we have no piece layer for it.
+func (t TorrentInfo) needsPieceProof(index int) bool {
+	if !t.isV2Only() {
+		return false
+	}
+	f, _, ok := t.pieceFile(index)
+	if !ok || f.Length <= t.PieceLength {
+		return false
+	}
+	_, ok = t.PieceLayers[string(f.PiecesRoot)]
+	return !ok
+}
+
+// requestPieceProof asks the peer on conn for the hashes that prove piece
+// index against its file's pieces root, in the form verifyPieceProof takes.
+// Hashes are requested in aligned pairs from the piece layer, so the proof
+// is the piece's sibling in the pair followed by the uncles the peer sends.
+func requestPieceProof(conn net.Conn, torrentInfo TorrentInfo, index int) ([][]byte, error) {
+	f, filePiece, ok := torrentInfo.pieceFile(index)
+	if !ok {
+		return nil, fmt.Errorf("piece %d out of range", index)
+	}
+	numberOfPieces := (f.Length + torrentInfo.PieceLength - 1) / torrentInfo.PieceLength
+	// The pair's parent is this many layers below the root.
+	uncles := bits.TrailingZeros(uint(nextPowerOfTwo(int(numberOfPieces)))) - 1
+
+	payload := make([]byte, sha256.Size+16)
+	copy(payload, f.PiecesRoot)
+	binary.BigEndian.PutUint32(payload[32:36], uint32(bits.TrailingZeros(uint(torrentInfo.PieceLength/merkleBlockSize))))
+	binary.BigEndian.PutUint32(payload[36:40], uint32(filePiece&^1))
+	binary.BigEndian.PutUint32(payload[40:44], 2)
+	binary.BigEndian.PutUint32(payload[44:48], uint32(uncles))
+	if err := sendMessage(conn, PeerMessage{
+		PayloadLength: int32(1 + len(payload)),
+		Id:            hashRequest,
+		Payload:       payload,
+	}); err != nil {
+		return nil, fmt.Errorf("error sending hash request: %w", err)
+	}
+
+	// The answer repeats the request, so anything else can be skipped.
+	for {
+		msg, err := waitForMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Payload) < len(payload) || !bytes.Equal(msg.Payload[:len(payload)], payload) {
+			continue
+		}
+		switch msg.Id {
+		case hashReject:
+			return nil, fmt.Errorf("peer rejected the hash request for piece %d", index)
+		case hashes:
+			received := msg.Payload[len(payload):]
+			if len(received) != (2+uncles)*sha256.Size {
+				return nil, fmt.Errorf("hashes for piece %d are %d bytes, expected %d", index, len(received), (2+uncles)*sha256.Size)
+			}
+			sibling := filePiece&1 ^ 1
+			proof := [][]byte{received[sibling*sha256.Size : (sibling+1)*sha256.Size]}
+			for i := 2; i < 2+uncles; i++ {
+				proof = append(proof, received[i*sha256.Size:(i+1)*sha256.Size])
+			}
+			return proof, nil
+		}
+	}
+}
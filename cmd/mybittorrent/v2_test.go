@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func sha256Of(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// testContent returns n bytes of content that differ from block to block.
+func testContent(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i/merkleBlockSize*7 + i%251)
+	}
+	return data
+}
+
+func TestPieceHashV2(t *testing.T) {
+	zero := make([]byte, sha256.Size)
+	data := testContent(3*merkleBlockSize + 100)
+	block := func(i int) []byte {
+		end := (i + 1) * merkleBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		return sha256Of(data[i*merkleBlockSize : end])
+	}
+
+	tests := []struct {
+		name                    string
+		data                    []byte
+		fileLength, pieceLength int64
+		want                    []byte
+	}{
+		{"single block file", data[:100], 100, 1 << 20, sha256Of(data[:100])},
+		{"two block file", data[:2*merkleBlockSize], 2 * merkleBlockSize, 1 << 20,
+			hashPair(block(0), block(1))},
+		// A file of one piece is padded to a power of two of blocks.
+		{"partial last block", data, int64(len(data)), 1 << 20,
+			hashPair(hashPair(block(0), block(1)), hashPair(block(2), block(3)))},
+		{"three block file", data[:3*merkleBlockSize], 3 * merkleBlockSize, 1 << 20,
+			hashPair(hashPair(block(0), block(1)), hashPair(block(2), zero))},
+		// The last piece of a longer file is padded to a full piece.
+		{"short last piece", data[:100], 5 * merkleBlockSize, 4 * merkleBlockSize,
+			hashPair(hashPair(sha256Of(data[:100]), zero), hashPair(zero, zero))},
+	}
+	for _, tt := range tests {
+		if got := pieceHashV2(tt.data, tt.fileLength, tt.pieceLength); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: hash %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := sha256Of([]byte("a")), sha256Of([]byte("b")), sha256Of([]byte("c"))
+	pad := sha256Of([]byte("pad"))
+	tests := []struct {
+		hashes [][]byte
+		width  int
+		want   []byte
+	}{
+		{[][]byte{a}, 1, a},
+		{[][]byte{a, b}, 2, hashPair(a, b)},
+		{[][]byte{a, b, c}, 4, hashPair(hashPair(a, b), hashPair(c, pad))},
+		{nil, 2, hashPair(pad, pad)},
+	}
+	for _, tt := range tests {
+		if got := merkleRoot(tt.hashes, tt.width, pad); !bytes.Equal(got, tt.want) {
+			t.Errorf("merkleRoot of %d hashes to width %d = %x, want %x", len(tt.hashes), tt.width, got, tt.want)
+		}
+	}
+}
+
+// testTree is a v2 file of five 32 KiB pieces, the last 100 bytes long,
+// and its merkle tree from the piece layer up.
+type testTree struct {
+	data   []byte
+	layer  [][]byte
+	pad    []byte
+	level1 [][]byte
+	level2 [][]byte
+	root   []byte
+}
+
+const testPieceLength = 2 * merkleBlockSize
+
+func newTestTree() testTree {
+	zero := make([]byte, sha256.Size)
+	tree := testTree{data: testContent(4*testPieceLength + 100)}
+	fileLength := int64(len(tree.data))
+	for begin := 0; begin < len(tree.data); begin += testPieceLength {
+		end := begin + testPieceLength
+		if end > len(tree.data) {
+			end = len(tree.data)
+		}
+		tree.layer = append(tree.layer, pieceHashV2(tree.data[begin:end], fileLength, testPieceLength))
+	}
+	// Pieces past the end of the file hash as a piece of zero blocks.
+	tree.pad = hashPair(zero, zero)
+	tree.level1 = [][]byte{
+		hashPair(tree.layer[0], tree.layer[1]),
+		hashPair(tree.layer[2], tree.layer[3]),
+		hashPair(tree.layer[4], tree.pad),
+		hashPair(tree.pad, tree.pad),
+	}
+	tree.level2 = [][]byte{
+		hashPair(tree.level1[0], tree.level1[1]),
+		hashPair(tree.level1[2], tree.level1[3]),
+	}
+	tree.root = hashPair(tree.level2[0], tree.level2[1])
+	return tree
+}
+
+func (tree testTree) torrentInfo() TorrentInfo {
+	return TorrentInfo{
+		MetaVersion: 2,
+		PieceLength: testPieceLength,
+		TotalLength: int64(len(tree.data)),
+		Files:       []TorrentFile{{Path: []string{"f"}, Length: int64(len(tree.data)), PiecesRoot: tree.root}},
+	}
+}
+
+func (tree testTree) piece(index int) []byte {
+	end := (index + 1) * testPieceLength
+	if end > len(tree.data) {
+		end = len(tree.data)
+	}
+	return tree.data[index*testPieceLength : end]
+}
+
+func TestCheckPieceLayer(t *testing.T) {
+	tree := newTestTree()
+	layer := bytes.Join(tree.layer, nil)
+	fileLength := int64(len(tree.data))
+
+	if err := checkPieceLayer(layer, tree.root, fileLength, testPieceLength); err != nil {
+		t.Errorf("valid piece layer: %v", err)
+	}
+	wrongHash := append([]byte(nil), layer...)
+	wrongHash[40] ^= 1
+	for _, tt := range []struct {
+		name  string
+		layer []byte
+		root  []byte
+		want  string
+	}{
+		{"short layer", layer[:len(layer)-sha256.Size], tree.root, "expected"},
+		{"long layer", append(append([]byte(nil), layer...), tree.pad...), tree.root, "expected"},
+		{"ragged layer", layer[:len(layer)-1], tree.root, "expected"},
+		{"wrong hash", wrongHash, tree.root, "does not match"},
+		{"wrong root", layer, tree.layer[0], "does not match"},
+	} {
+		err := checkPieceLayer(tt.layer, tt.root, fileLength, testPieceLength)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyPieceProof(t *testing.T) {
+	tree := newTestTree()
+	torrentInfo := tree.torrentInfo()
+	proofs := [][][]byte{
+		{tree.layer[1], tree.level1[1], tree.level2[1]},
+		{tree.layer[0], tree.level1[1], tree.level2[1]},
+		{tree.layer[3], tree.level1[0], tree.level2[1]},
+		{tree.layer[2], tree.level1[0], tree.level2[1]},
+		{tree.pad, tree.level1[3], tree.level2[0]},
+	}
+	for index, proof := range proofs {
+		if err := torrentInfo.verifyPieceProof(index, tree.piece(index), proof); err != nil {
+			t.Errorf("piece %d: %v", index, err)
+		}
+		for i := range proof {
+			flipped := append([][]byte(nil), proof...)
+			flipped[i] = append([]byte(nil), proof[i]...)
+			flipped[i][0] ^= 1
+			if err := torrentInfo.verifyPieceProof(index, tree.piece(index), flipped); err == nil {
+				t.Errorf("piece %d verified with hash %d of its proof flipped", index, i)
+			}
+		}
+		if err := torrentInfo.verifyPieceProof(index, tree.piece(index), proof[:len(proof)-1]); err == nil {
+			t.Errorf("piece %d verified with a short proof", index)
+		}
+	}
+	if err := torrentInfo.verifyPieceProof(0, tree.piece(1), proofs[0]); err == nil {
+		t.Error("piece 1's data verified as piece 0")
+	}
+}
+
+// readMessage reads one peer message from conn.
+func readMessage(t *testing.T, conn net.Conn) (MessageId, []byte) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header)-1)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Error(err)
+	}
+	return MessageId(header[4]), payload
+}
+
+func writeMessage(conn net.Conn, id MessageId, payload []byte) {
+	message := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(message, uint32(1+len(payload)))
+	message[4] = byte(id)
+	conn.Write(append(message, payload...))
+}
+
+func TestRequestPieceProof(t *testing.T) {
+	tree := newTestTree()
+	torrentInfo := tree.torrentInfo()
+	if !torrentInfo.needsPieceProof(4) {
+		t.Fatal("piece of a multi-piece file without a piece layer needs no proof")
+	}
+
+	for _, reject := range []bool{false, true} {
+		conn, peer := net.Pipe()
+		go func() {
+			defer peer.Close()
+			id, request := readMessage(t, peer)
+			want := make([]byte, sha256.Size+16)
+			copy(want, tree.root)
+			// Base layer 1 (two blocks a piece), the pair at 4, two uncles.
+			binary.BigEndian.PutUint32(want[32:], 1)
+			binary.BigEndian.PutUint32(want[36:], 4)
+			binary.BigEndian.PutUint32(want[40:], 2)
+			binary.BigEndian.PutUint32(want[44:], 2)
+			if id != hashRequest || !bytes.Equal(request, want) {
+				t.Errorf("hash request %d %x, want %d %x", id, request, hashRequest, want)
+			}
+			// Unrelated messages and answers to other requests are skipped.
+			writeMessage(peer, have, []byte{0, 0, 0, 1})
+			other := append([]byte(nil), request...)
+			other[39] = 2
+			writeMessage(peer, hashes, append(other, bytes.Repeat([]byte{1}, 4*sha256.Size)...))
+			if reject {
+				writeMessage(peer, hashReject, request)
+				return
+			}
+			writeMessage(peer, hashes, bytes.Join([][]byte{request, tree.layer[4], tree.pad, tree.level1[3], tree.level2[0]}, nil))
+		}()
+
+		proof, err := requestPieceProof(conn, torrentInfo, 4)
+		conn.Close()
+		if reject {
+			if err == nil {
+				t.Error("rejected hash request returned a proof")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := torrentInfo.verifyPieceProof(4, tree.piece(4), proof); err != nil {
+			t.Errorf("proof from hashes message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity says how bad a validation finding is. Errors make a torrent
+// unusable or unsafe; warnings are legal but suspect.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Finding is one problem validateTorrent found.
+type Finding struct {
+	Severity Severity
+	Message  string
+}
+
+// runValidate implements the validate command:
+//
+//	validate <torrent>...
+//
+// It prints every finding for each torrent and fails if any has errors.
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: validate <torrent>...")
+	}
+
+	failed := 0
+	for _, path := range flags.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		findings := validateTorrent(content)
+		errorCount := 0
+		for _, finding := range findings {
+			fmt.Printf("%s: %s: %s\n", path, finding.Severity, finding.Message)
+			if finding.Severity == SeverityError {
+				errorCount++
+			}
+		}
+		fmt.Printf("%s: %d errors, %d warnings\n", path, errorCount, len(findings)-errorCount)
+		if errorCount > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d torrents failed validation", failed, flags.NArg())
+	}
+	return nil
+}
+
+// validateTorrent checks metainfo against BEP 3 and the extensions this
+// client supports, and reports every problem rather than stopping at the
+// first.
+func validateTorrent(content []byte) []Finding {
+	var findings []Finding
+	report := func(severity Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	decoder := bencode.NewDecoder(bytes.NewReader(content))
+	metadata := Metadata{}
+	err := decoder.DecodeInto(&metadata)
+	var typeErr *bencode.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		report(SeverityError, "%v", err)
+	case err != nil:
+		report(SeverityError, "not a bencoded dictionary: %v", err)
+		return findings
+	}
+	if _, err := bencode.DecodeBytesStrict(content); err != nil {
+		report(SeverityWarning, "not canonical bencoding: %v", err)
+	}
+	if len(metadata.Info) == 0 {
+		report(SeverityError, "missing info dictionary")
+		return findings
+	}
+	if metadata.Announce == "" && len(metadata.AnnounceList) == 0 && len(metadata.URLList) == 0 {
+		report(SeverityWarning, "no trackers or web seeds")
+	}
+	for _, field := range []struct {
+		key string
+		raw bencode.RawMessage
+	}{
+		{"comment", metadata.Comment},
+		{"created by", metadata.CreatedBy},
+		{"encoding", metadata.Encoding},
+	} {
+		if _, ok := rawString(field.raw); !ok {
+			report(SeverityWarning, "%s is not a string", field.key)
+		}
+	}
+	if _, ok := rawInt(metadata.CreationDate); !ok {
+		report(SeverityWarning, "creation date is not an integer")
+	}
+
+	info := MetadataInfo{}
+	if err := bencode.Unmarshal(metadata.Info, &info); err != nil {
+		if !errors.As(err, &typeErr) {
+			report(SeverityError, "invalid info dictionary: %v", err)
+			return findings
+		}
+		report(SeverityError, "%v", err)
+	}
+
+	switch {
+	case info.Name == "":
+		report(SeverityError, "name is empty")
+	default:
+		checkComponent(report, "name", info.Name)
+	}
+	switch {
+	case info.PieceLength <= 0:
+		report(SeverityError, "piece length %d is not positive", info.PieceLength)
+	case info.PieceLength&(info.PieceLength-1) != 0:
+		report(SeverityWarning, "piece length %d is not a power of two", info.PieceLength)
+	}
+
+	// v2-only torrents have no v1 pieces or lengths; their file tree is
+	// checked below.
+	if info.MetaVersion < 2 || info.Pieces != "" {
+		var totalLength int64
+		if len(info.Files) == 0 {
+			if info.Length <= 0 {
+				report(SeverityError, "length %d is not positive", info.Length)
+			}
+			totalLength = info.Length
+		} else {
+			if info.Length != 0 {
+				report(SeverityError, "both length and files are set")
+			}
+			totalLength = checkFiles(report, info.Files)
+		}
+
+		if len(info.Pieces)%20 != 0 {
+			report(SeverityError, "pieces is %d bytes, not a multiple of 20", len(info.Pieces))
+		} else if info.PieceLength > 0 && totalLength > 0 {
+			expected := (totalLength + info.PieceLength - 1) / info.PieceLength
+			if int64(len(info.Pieces)/20) != expected {
+				report(SeverityError, "%d piece hashes for %d bytes in pieces of %d, expected %d",
+					len(info.Pieces)/20, totalLength, info.PieceLength, expected)
+			}
+		}
+	}
+
+	// A v2 file tree gets the same path checks as a v1 file list. A hybrid
+	// torrent's v1 list was checked above, and parsing makes sure the tree
+	// matches it, so only the tree's own components are left to check.
+	if info.MetaVersion >= 2 && len(info.FileTree) > 0 {
+		files, _, err := getFilesV2(info)
+		switch {
+		case err != nil:
+			report(SeverityError, "%v", err)
+		case info.Pieces == "":
+			checkFiles(report, v2MetadataFiles(files))
+		default:
+			for i, f := range files {
+				for _, component := range f.Path {
+					checkComponent(report, fmt.Sprintf("file tree file %d path", i), component)
+				}
+			}
+		}
+	}
+
+	// Whatever the checks above missed, parsing still catches, such as
+	// piece layers that don't match their pieces roots.
+	if !hasErrors(findings) {
+		if _, err := torrentInfoFromMetadata(metadata); err != nil {
+			report(SeverityError, "%v", err)
+		}
+	}
+	return findings
+}
+
+func hasErrors(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFiles checks the files of a multi-file torrent and returns their
+// total length.
+func checkFiles(report func(Severity, string, ...interface{}), files []MetadataFile) int64 {
+	var totalLength int64
+	seen := make(map[string]int)
+	folded := make(map[string]int)
+	directories := make(map[string]int)
+	for i, f := range files {
+		name := strings.Join(f.Path, "/")
+		switch {
+		case f.Length < 0:
+			report(SeverityError, "file %d (%s) has negative length %d", i, name, f.Length)
+		case f.Length == 0 && !strings.ContainsRune(f.Attr, 'l'):
+			report(SeverityWarning, "file %d (%s) is empty", i, name)
+		default:
+			totalLength += f.Length
+		}
+		if len(f.Path) == 0 {
+			report(SeverityError, "file %d has an empty path", i)
+			continue
+		}
+		for _, component := range f.Path {
+			checkComponent(report, fmt.Sprintf("file %d path", i), component)
+		}
+		if strings.ContainsRune(f.Attr, 'l') {
+			for _, component := range f.SymlinkPath {
+				checkComponent(report, fmt.Sprintf("file %d symlink path", i), component)
+			}
+		}
+
+		if j, ok := seen[name]; ok {
+			report(SeverityError, "files %d and %d have the same path %s", j, i, name)
+		} else if j, ok := folded[strings.ToLower(name)]; ok {
+			report(SeverityWarning, "files %d and %d collide on case-insensitive filesystems: %s", j, i, name)
+		}
+		seen[name] = i
+		folded[strings.ToLower(name)] = i
+		for k := 1; k < len(f.Path); k++ {
+			directories[strings.Join(f.Path[:k], "/")] = i
+		}
+	}
+	for i, f := range files {
+		name := strings.Join(f.Path, "/")
+		if j, ok := directories[name]; ok && len(f.Path) > 0 {
+			report(SeverityError, "file %d (%s) is also a directory of file %d", i, name, j)
+		}
+	}
+	return totalLength
+}
+
+// v2MetadataFiles returns the files of a v2 file tree in the form of a v1
+// file list, for checkFiles.
+func v2MetadataFiles(files []TorrentFile) []MetadataFile {
+	metadataFiles := make([]MetadataFile, len(files))
+	for i, f := range files {
+		metadataFiles[i] = MetadataFile{Length: f.Length, Path: f.Path, SymlinkPath: f.SymlinkPath}
+		if f.SymlinkPath != nil {
+			metadataFiles[i].Attr = "l"
+		}
+	}
+	return metadataFiles
+}
+
+// checkComponent reports a path component that is not a plain, valid
+// UTF-8 file name.
+func checkComponent(report func(Severity, string, ...interface{}), what, component string) {
+	switch {
+	case component == "":
+		report(SeverityError, "%s has an empty component", what)
+	case component == "." || component == "..":
+		report(SeverityError, "%s has a %q component", what, component)
+	case strings.ContainsAny(component, `/\`):
+		report(SeverityError, "%s component %q contains a path separator", what, component)
+	case len(component) >= 2 && component[1] == ':':
+		report(SeverityError, "%s component %q starts with a drive letter", what, component)
+	case strings.ContainsRune(component, 0):
+		report(SeverityError, "%s component %q contains a NUL byte", what, component)
+	}
+	if !utf8.ValidString(component) {
+		report(SeverityError, "%s component %q is not valid UTF-8", what, component)
+	}
+}
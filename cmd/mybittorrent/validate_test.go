@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"strings"
+	"testing"
+)
+
+// v2File is a file tree entry for a file of length bytes, short enough to
+// need no piece layer.
+func v2File(length int64) map[string]interface{} {
+	return map[string]interface{}{"": map[string]interface{}{
+		"length":      length,
+		"pieces root": string(bytes.Repeat([]byte{0xaa}, 32)),
+	}}
+}
+
+// v2Torrent returns a v2-only torrent with tree as its file tree.
+func v2Torrent(t *testing.T, tree map[string]interface{}) []byte {
+	t.Helper()
+	content, err := bencode.Marshal(map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"file tree":    tree,
+			"meta version": 2,
+			"name":         "dir",
+			"piece length": 16384,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestValidateV2FileTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		tree     map[string]interface{}
+		severity Severity
+		want     string // in a finding of that severity; empty for none
+	}{
+		{"valid", map[string]interface{}{"a": v2File(5), "sub": map[string]interface{}{"b": v2File(7)}}, SeverityError, ""},
+		{"dot dot", map[string]interface{}{"..": v2File(5)}, SeverityError, `has a ".." component`},
+		{"nested dot dot", map[string]interface{}{"sub": map[string]interface{}{"..": v2File(5)}}, SeverityError, `has a ".." component`},
+		{"empty component", map[string]interface{}{"sub": map[string]interface{}{"": v2File(5)}}, SeverityError, `"sub"`},
+		{"separator", map[string]interface{}{"a/b": v2File(5)}, SeverityError, "contains a path separator"},
+		{"invalid UTF-8", map[string]interface{}{"\xff": v2File(5)}, SeverityError, "is not valid UTF-8"},
+		{"case collision", map[string]interface{}{"A": v2File(5), "a": v2File(5)}, SeverityWarning, "collide on case-insensitive"},
+	}
+	for _, tt := range tests {
+		findings := validateTorrent(v2Torrent(t, tt.tree))
+		var matching []string
+		for _, finding := range findings {
+			if finding.Severity == tt.severity {
+				matching = append(matching, finding.Message)
+			}
+		}
+		switch {
+		case tt.want == "" && len(matching) > 0:
+			t.Errorf("%s: unexpected %ss %q", tt.name, tt.severity, matching)
+		case tt.want != "" && !strings.Contains(strings.Join(matching, "\n"), tt.want):
+			t.Errorf("%s: %ss %q, want one containing %q", tt.name, tt.severity, matching, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webSeedTimeout bounds one range request, which fetches at most a piece.
+const webSeedTimeout = 2 * time.Minute
+
+var webSeedClient = &http.Client{Timeout: webSeedTimeout}
+
+// webSeed fetches pieces over HTTP from a server holding the torrent's
+// files (BEP 19).
+type webSeed struct {
+	url string
+}
+
+// getWebSeeds reads url-list, which is a single URL or a list of them.
+func getWebSeeds(urlList bencode.RawMessage) ([]string, error) {
+	if len(urlList) == 0 {
+		return nil, nil
+	}
+	var single string
+	if err := bencode.Unmarshal(urlList, &single); err == nil {
+		if single == "" {
+			return nil, nil
+		}
+		return []string{single}, nil
+	}
+	var list []string
+	if err := bencode.Unmarshal(urlList, &list); err != nil {
+		return nil, fmt.Errorf("url-list is neither a URL nor a list of URLs")
+	}
+	var webSeeds []string
+	for _, seedURL := range list {
+		if seedURL != "" {
+			webSeeds = append(webSeeds, seedURL)
+		}
+	}
+	return webSeeds, nil
+}
+
+// fetchPiece reads piece index from the files it spans and checks it
+// against its hash.
+func (s *webSeed) fetchPiece(torrentInfo TorrentInfo, index int) ([]byte, error) {
+	start := torrentInfo.pieceOffset(index)
+	end := start + torrentInfo.pieceLength(index)
+	data := make([]byte, 0, end-start)
+	for _, f := range torrentInfo.Files {
+		if f.Length == 0 || f.Offset+f.Length <= start || f.Offset >= end {
+			continue
+		}
+		from, to := start-f.Offset, end-f.Offset
+		if from < 0 {
+			from = 0
+		}
+		if to > f.Length {
+			to = f.Length
+		}
+		if f.Padding {
+			data = append(data, make([]byte, to-from)...)
+			continue
+		}
+		part, err := s.fetchRange(s.fileURL(torrentInfo, f), from, to)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, part...)
+	}
+	if err := torrentInfo.verifyPiece(index, data); err != nil {
+		return nil, fmt.Errorf("piece hashes doesnt match: %w", err)
+	}
+	return data, nil
+}
+
+// fileURL returns the URL of file f. A single-file torrent's URL names the
+// file itself unless it ends in a slash; multi-file torrents live under
+// <url>/<name>/<path>.
+func (s *webSeed) fileURL(torrentInfo TorrentInfo, f TorrentFile) string {
+	fileURL := s.url
+	if !torrentInfo.MultiFile {
+		if strings.HasSuffix(fileURL, "/") {
+			fileURL += url.PathEscape(torrentInfo.Name)
+		}
+		return fileURL
+	}
+	if !strings.HasSuffix(fileURL, "/") {
+		fileURL += "/"
+	}
+	parts := []string{url.PathEscape(torrentInfo.Name)}
+	for _, component := range f.Path {
+		parts = append(parts, url.PathEscape(component))
+	}
+	return fileURL + strings.Join(parts, "/")
+}
+
+// fetchRange returns bytes [from, to) of the file at fileURL.
+func (s *webSeed) fetchRange(fileURL string, from, to int64) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to-1))
+	resp, err := webSeedClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && from == 0:
+		// The server ignored the range; the start of the file is all we need.
+	default:
+		return nil, fmt.Errorf("%s returned %s", fileURL, resp.Status)
+	}
+	data := make([]byte, to-from)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", fileURL, err)
+	}
+	return data, nil
+}
+
+func (s *webSeed) Close() error {
+	return nil
+}
+
+func (s *webSeed) String() string {
+	return "web seed " + s.url
+}